@@ -0,0 +1,74 @@
+package mysqlbatch
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// outParamPattern matches user variables, which are pasted into SELECT as is
+var outParamPattern = regexp.MustCompile(`^@[A-Za-z0-9_$.]+$`)
+
+// queryOutParams selects OUT parameters of CALL requested by `-- mysqlbatch:out-params @total @count`.
+// If no variable is listed, user variables in the arguments of CALL are selected.
+// read is called with the result set of the parameters.
+func (e *Executer) queryOutParams(ctx context.Context, q queryer, query string, a annotation, read func(columns []string, iter *sql.Rows) error) error {
+	var names []string
+	for key := range a {
+		names = append(names, key)
+	}
+	if len(names) == 0 {
+		seen := make(map[string]bool)
+		for _, t := range tokenize(query) {
+			if t.kind == tokenWord && strings.HasPrefix(t.text, "@") && !strings.HasPrefix(t.text, "@@") && !seen[t.text] {
+				seen[t.text] = true
+				names = append(names, t.text)
+			}
+		}
+	} else {
+		// annotation is a map, so the order follows the query
+		sortByPosition(names, query)
+	}
+	if len(names) == 0 {
+		return errors.New("out-params requires user variables like @total")
+	}
+	for _, name := range names {
+		if !outParamPattern.MatchString(name) {
+			return errors.Errorf("out-params must be user variables like @total, got `%s`", name)
+		}
+	}
+	iter, err := q.QueryContext(ctx, "SELECT "+strings.Join(names, ", "))
+	if err != nil {
+		return errors.Wrap(err, "select out params")
+	}
+	defer iter.Close()
+	columns, err := iter.Columns()
+	if err != nil {
+		return err
+	}
+	if err := read(columns, iter); err != nil {
+		return err
+	}
+	return iter.Close()
+}
+
+// sortByPosition sorts names by the first position in query
+func sortByPosition(names []string, query string) {
+	position := func(name string) int {
+		if i := strings.Index(query, name); i >= 0 {
+			return i
+		}
+		return len(query)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := position(names[i]), position(names[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return names[i] < names[j]
+	})
+}
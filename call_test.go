@@ -0,0 +1,78 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuterExecute__WithCall(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+USE mysqlbatch;
+DROP PROCEDURE IF EXISTS call_results;
+`), nil))
+	// the procedure body has semicolons, so it is created directly
+	_, err = e.DB().Exec("CREATE PROCEDURE mysqlbatch.call_results(IN n INT, OUT total INT) BEGIN SET total = n * 10; SELECT n + 1 AS b, n + 2 AS c; END")
+	require.NoError(t, err)
+
+	type resultSet struct {
+		query   string
+		index   int
+		columns []string
+		rows    [][]string
+	}
+	var resultSets []resultSet
+	e.SetResultSetHook(func(query string, index int, columns []string, rows [][]string) {
+		resultSets = append(resultSets, resultSet{query: query, index: index, columns: columns, rows: rows})
+	})
+	err = e.Execute(strings.NewReader(`
+USE mysqlbatch;
+-- mysqlbatch:out-params
+CALL call_results(1, @total);
+SELECT 'done';
+`), nil)
+	require.NoError(t, err)
+	require.Equal(t, []resultSet{
+		{query: "CALL call_results(1, @total)", index: 0, columns: []string{"b", "c"}, rows: [][]string{{"2", "3"}}},
+		{query: "CALL call_results(1, @total)", index: 1, columns: []string{"@total"}, rows: [][]string{{"10"}}},
+		{query: "SELECT 'done'", index: 0, columns: []string{"done"}, rows: [][]string{{"done"}}},
+	}, resultSets)
+}
+
+func TestExecuterExecute__WithCallInvalidOutParams(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+USE mysqlbatch;
+DROP PROCEDURE IF EXISTS call_results;
+`), nil))
+	_, err = e.DB().Exec("CREATE PROCEDURE mysqlbatch.call_results(IN n INT, OUT total INT) BEGIN SET total = n * 10; SELECT n + 1 AS b, n + 2 AS c; END")
+	require.NoError(t, err)
+
+	var columns [][]string
+	e.SetResultSetHook(func(_ string, _ int, c []string, _ [][]string) {
+		columns = append(columns, c)
+	})
+	for _, params := range []string{"@total,(SELECT(COUNT(*))FROM(mysqlbatch.call_results))", "@total+1", "@@version", "@"} {
+		t.Run(params, func(t *testing.T) {
+			columns = nil
+			err := e.Execute(strings.NewReader("USE mysqlbatch;\n-- mysqlbatch:out-params "+params+"\nCALL call_results(1, @total);\n"), nil)
+			require.ErrorContains(t, err, "out-params must be user variables like @total, got `")
+			require.Equal(t, [][]string{{"b", "c"}}, columns, "out params are not selected")
+		})
+	}
+}
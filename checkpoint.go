@@ -0,0 +1,236 @@
+package mysqlbatch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Checkpoint is the progress of the batch, the index of the last successfully executed statement and the hash of the rendered SQL
+type Checkpoint struct {
+	SQLHash        string `json:"sql_hash"`
+	StatementIndex int    `json:"statement_index"`
+}
+
+// CheckpointStore saves Checkpoint by key
+type CheckpointStore interface {
+	// LoadCheckpoint returns nil if no checkpoint is saved
+	LoadCheckpoint(ctx context.Context, key string) (*Checkpoint, error)
+	SaveCheckpoint(ctx context.Context, key string, cp *Checkpoint) error
+	ClearCheckpoint(ctx context.Context, key string) error
+}
+
+type checkpointer struct {
+	store  CheckpointStore
+	key    string
+	resume bool
+}
+
+// SetCheckpoint set CheckpointStore recording the last successfully executed statement by key.
+// If resume is true, statements completed in the previous failed execution are skipped when the rendered SQL hash matches.
+// The checkpoint is cleared when the execution succeeds.
+// It is not available with parallel execution of statement groups.
+func (e *Executer) SetCheckpoint(store CheckpointStore, key string, resume bool) {
+	if store == nil {
+		e.checkpointer = nil
+		return
+	}
+	e.checkpointer = &checkpointer{
+		store:  store,
+		key:    key,
+		resume: resume,
+	}
+}
+
+// prepareCheckpoint is called after rendering, and sets the statement index to resume from
+func (e *Executer) prepareCheckpoint(ctx context.Context) error {
+	if e.checkpointer == nil {
+		return nil
+	}
+	if e.parallelism > 1 {
+		return errors.New("checkpoint is not available with parallel execution")
+	}
+	if !e.checkpointer.resume {
+		return nil
+	}
+	cp, err := e.checkpointer.store.LoadCheckpoint(ctx, e.checkpointer.key)
+	if err != nil {
+		return errors.Wrap(err, "load checkpoint")
+	}
+	if cp == nil {
+		return nil
+	}
+	if cp.SQLHash != e.current.hash() {
+		log.Printf("checkpoint `%s` is for another rendered SQL, execute from the beginning", e.checkpointer.key)
+		return nil
+	}
+	e.current.resumeFrom = cp.StatementIndex + 1
+	log.Printf("resume from checkpoint `%s`, skip %d completed statements", e.checkpointer.key, e.current.resumeFrom)
+	return nil
+}
+
+func (e *Executer) saveCheckpoint(ctx context.Context, index int) error {
+	if e.checkpointer == nil {
+		return nil
+	}
+	err := e.checkpointer.store.SaveCheckpoint(ctx, e.checkpointer.key, &Checkpoint{
+		SQLHash:        e.current.hash(),
+		StatementIndex: index,
+	})
+	return errors.Wrap(err, "save checkpoint")
+}
+
+func (e *Executer) clearCheckpoint(ctx context.Context) error {
+	if e.checkpointer == nil {
+		return nil
+	}
+	return errors.Wrap(e.checkpointer.store.ClearCheckpoint(ctx, e.checkpointer.key), "clear checkpoint")
+}
+
+// FileCheckpointStore saves Checkpoint as JSON file named by key in the directory
+type FileCheckpointStore struct {
+	dir string
+}
+
+// NewFileCheckpointStore returns FileCheckpointStore
+func NewFileCheckpointStore(dir string) *FileCheckpointStore {
+	return &FileCheckpointStore{dir: dir}
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^\w.-]`)
+
+func (s *FileCheckpointStore) path(key string) string {
+	return filepath.Join(s.dir, unsafeFileNameChars.ReplaceAllString(key, "_")+".checkpoint.json")
+}
+
+// LoadCheckpoint reads the checkpoint file
+func (s *FileCheckpointStore) LoadCheckpoint(_ context.Context, key string) (*Checkpoint, error) {
+	bs, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(bs, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// SaveCheckpoint writes the checkpoint file atomically
+func (s *FileCheckpointStore) SaveCheckpoint(_ context.Context, key string, cp *Checkpoint) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	bs, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+// ClearCheckpoint removes the checkpoint file
+func (s *FileCheckpointStore) ClearCheckpoint(_ context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// TableCheckpointStore saves Checkpoint into the table, creating it if missing.
+// db should not be the one of Executer, because the executer connection is in use while saving.
+type TableCheckpointStore struct {
+	db      *sql.DB
+	table   string
+	mu      sync.Mutex
+	created bool
+}
+
+// NewTableCheckpointStore returns TableCheckpointStore. table can be qualified by database name as `db.table`.
+func NewTableCheckpointStore(db *sql.DB, table string) *TableCheckpointStore {
+	return &TableCheckpointStore{
+		db:    db,
+		table: quoteIdentifier(table),
+	}
+}
+
+// LoadCheckpoint selects the checkpoint row
+func (s *TableCheckpointStore) LoadCheckpoint(ctx context.Context, key string) (*Checkpoint, error) {
+	if err := s.createTable(ctx); err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT sql_hash, statement_index FROM %s WHERE checkpoint_key = ?", s.table),
+		key,
+	).Scan(&cp.SQLHash, &cp.StatementIndex)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// SaveCheckpoint upserts the checkpoint row
+func (s *TableCheckpointStore) SaveCheckpoint(ctx context.Context, key string, cp *Checkpoint) error {
+	if err := s.createTable(ctx); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (checkpoint_key, sql_hash, statement_index) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE sql_hash = VALUES(sql_hash), statement_index = VALUES(statement_index)", s.table),
+		key, cp.SQLHash, cp.StatementIndex,
+	)
+	return err
+}
+
+// ClearCheckpoint deletes the checkpoint row
+func (s *TableCheckpointStore) ClearCheckpoint(ctx context.Context, key string) error {
+	if err := s.createTable(ctx); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE checkpoint_key = ?", s.table), key)
+	return err
+}
+
+func (s *TableCheckpointStore) createTable(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.created {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    checkpoint_key VARCHAR(191) NOT NULL,
+    sql_hash CHAR(64) NOT NULL,
+    statement_index INTEGER NOT NULL,
+    PRIMARY KEY (checkpoint_key)
+)`, s.table))
+	if err != nil {
+		return errors.Wrap(err, "create checkpoint table")
+	}
+	s.created = true
+	return nil
+}
@@ -0,0 +1,191 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+const testCheckpointSQL = `
+USE mysqlbatch;
+INSERT INTO checkpoint_events(name) VALUES ('first');
+INSERT INTO checkpoint_events(name) VALUES ('second');
+INSERT INTO checkpoint_events(name) SELECT name FROM checkpoint_sources;
+`
+
+func TestExecuterExecute__WithCheckpoint(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+USE mysqlbatch;
+DROP TABLE IF EXISTS checkpoint_sources;
+DROP TABLE IF EXISTS checkpoint_events;
+CREATE TABLE checkpoint_events (
+    id INTEGER auto_increment,
+    name VARCHAR(32),
+    PRIMARY KEY (id)
+);
+`), nil))
+
+	dir := t.TempDir()
+	store := mysqlbatch.NewFileCheckpointStore(dir)
+	e.SetCheckpoint(store, "events", true)
+	err = e.Execute(strings.NewReader(testCheckpointSQL), nil)
+	require.Error(t, err)
+	cp, err := store.LoadCheckpoint(context.Background(), "events")
+	require.NoError(t, err)
+	require.NotNil(t, cp)
+	require.Equal(t, 2, cp.StatementIndex)
+
+	e.SetCheckpoint(nil, "", false)
+	require.NoError(t, e.Execute(strings.NewReader(`
+USE mysqlbatch;
+CREATE TABLE checkpoint_sources (name VARCHAR(32));
+INSERT INTO checkpoint_sources(name) VALUES ('third');
+`), nil))
+
+	var queries []string
+	e.SetExecuteHook(func(query string, _, _ int64) {
+		queries = append(queries, query)
+	})
+	e.SetCheckpoint(store, "events", true)
+	require.NoError(t, e.Execute(strings.NewReader(testCheckpointSQL), nil))
+	require.Equal(t, []string{
+		"USE mysqlbatch",
+		"INSERT INTO checkpoint_events(name) SELECT name FROM checkpoint_sources",
+	}, queries, "completed statements are skipped, except USE")
+	_, err = os.Stat(filepath.Join(dir, "events.checkpoint.json"))
+	require.True(t, os.IsNotExist(err), "checkpoint is cleared after success")
+
+	var rows [][]string
+	e.SetSelectHook(func(_ string, _ []string, r [][]string) {
+		rows = r
+	})
+	require.NoError(t, e.Execute(strings.NewReader("SELECT name FROM mysqlbatch.checkpoint_events ORDER BY id;"), nil))
+	require.Equal(t, [][]string{{"first"}, {"second"}, {"third"}}, rows)
+}
+
+func TestExecuterExecute__WithCheckpointInTransaction(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+USE mysqlbatch;
+DROP TABLE IF EXISTS checkpoint_sources;
+DROP TABLE IF EXISTS checkpoint_events;
+CREATE TABLE checkpoint_events (
+    id INTEGER auto_increment,
+    name VARCHAR(32),
+    PRIMARY KEY (id)
+);
+`), nil))
+	const batch = `
+USE mysqlbatch;
+INSERT INTO checkpoint_events(name) VALUES ('first');
+START TRANSACTION;
+INSERT INTO checkpoint_events(name) VALUES ('second');
+INSERT INTO checkpoint_events(name) SELECT name FROM checkpoint_sources;
+COMMIT;
+`
+	store := mysqlbatch.NewFileCheckpointStore(t.TempDir())
+	e.SetCheckpoint(store, "events", true)
+	require.Error(t, e.Execute(strings.NewReader(batch), nil))
+	cp, err := store.LoadCheckpoint(context.Background(), "events")
+	require.NoError(t, err)
+	require.Equal(t, 1, cp.StatementIndex, "the checkpoint does not advance in the transaction")
+
+	e.SetCheckpoint(nil, "", false)
+	require.NoError(t, e.Execute(strings.NewReader(`
+USE mysqlbatch;
+CREATE TABLE checkpoint_sources (name VARCHAR(32));
+INSERT INTO checkpoint_sources(name) VALUES ('third');
+`), nil))
+
+	var queries []string
+	e.SetExecuteHook(func(query string, _, _ int64) {
+		queries = append(queries, query)
+	})
+	e.SetCheckpoint(store, "events", true)
+	require.NoError(t, e.Execute(strings.NewReader(batch), nil))
+	require.Equal(t, []string{
+		"USE mysqlbatch",
+		"START TRANSACTION",
+		"INSERT INTO checkpoint_events(name) VALUES ('second')",
+		"INSERT INTO checkpoint_events(name) SELECT name FROM checkpoint_sources",
+		"COMMIT",
+	}, queries, "the rolled back transaction is executed again")
+
+	var rows [][]string
+	e.SetSelectHook(func(_ string, _ []string, r [][]string) {
+		rows = r
+	})
+	require.NoError(t, e.Execute(strings.NewReader("SELECT name FROM mysqlbatch.checkpoint_events ORDER BY id;"), nil))
+	require.Equal(t, [][]string{{"first"}, {"second"}, {"third"}}, rows)
+}
+
+func TestExecuterExecute__WithCheckpointOfAnotherSQL(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	store := mysqlbatch.NewFileCheckpointStore(t.TempDir())
+	require.NoError(t, store.SaveCheckpoint(context.Background(), "job", &mysqlbatch.Checkpoint{
+		SQLHash:        "other",
+		StatementIndex: 1,
+	}))
+	var queries []string
+	e.SetExecuteHook(func(query string, _, _ int64) {
+		queries = append(queries, query)
+	})
+	e.SetCheckpoint(store, "job", true)
+	require.NoError(t, e.Execute(strings.NewReader("CREATE DATABASE IF NOT EXISTS mysqlbatch; SET @checkpoint = 1;"), nil))
+	require.Equal(t, []string{"CREATE DATABASE IF NOT EXISTS mysqlbatch", "SET @checkpoint = 1"}, queries)
+}
+
+func TestTableCheckpointStore(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	dsn, err := conf.GetDSN(context.Background())
+	require.NoError(t, err)
+	db, err := sql.Open("mysql", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	_, err = db.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS mysqlbatch")
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "DROP TABLE IF EXISTS mysqlbatch.mysqlbatch_checkpoints")
+	require.NoError(t, err)
+
+	store := mysqlbatch.NewTableCheckpointStore(db, "mysqlbatch.mysqlbatch_checkpoints")
+	cp, err := store.LoadCheckpoint(ctx, "job")
+	require.NoError(t, err)
+	require.Nil(t, cp)
+	require.NoError(t, store.SaveCheckpoint(ctx, "job", &mysqlbatch.Checkpoint{SQLHash: "hash", StatementIndex: 1}))
+	require.NoError(t, store.SaveCheckpoint(ctx, "job", &mysqlbatch.Checkpoint{SQLHash: "hash", StatementIndex: 2}))
+	cp, err = store.LoadCheckpoint(ctx, "job")
+	require.NoError(t, err)
+	require.Equal(t, &mysqlbatch.Checkpoint{SQLHash: "hash", StatementIndex: 2}, cp)
+	require.NoError(t, store.ClearCheckpoint(ctx, "job"))
+	cp, err = store.LoadCheckpoint(ctx, "job")
+	require.NoError(t, err)
+	require.Nil(t, cp)
+}
@@ -0,0 +1,149 @@
+package mysqlbatch
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultChunkSize = 1000
+
+// defaultMaxUpdateChunks bounds chunked UPDATE, whose condition may still match the updated rows
+const defaultMaxUpdateChunks = 1000
+
+var limitClausePattern = regexp.MustCompile(`(?i)\sLIMIT\s+\d+\s*$`)
+
+type chunkOptions struct {
+	size      int64
+	sleep     time.Duration
+	maxChunks int
+}
+
+// parseChunkAnnotation parses `-- mysqlbatch:chunk size=1000 sleep=500ms max_chunks=100`
+func parseChunkAnnotation(a annotation) (*chunkOptions, error) {
+	opts := &chunkOptions{
+		size: defaultChunkSize,
+	}
+	for key, value := range a {
+		switch key {
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size <= 0 {
+				return nil, errors.Errorf("chunk size must be positive integer, got `%s`", value)
+			}
+			opts.size = size
+		case "sleep":
+			sleep, err := time.ParseDuration(value)
+			if err != nil || sleep < 0 {
+				return nil, errors.Errorf("chunk sleep must be positive duration, got `%s`", value)
+			}
+			opts.sleep = sleep
+		case "max_chunks":
+			maxChunks, err := strconv.Atoi(value)
+			if err != nil || maxChunks < 0 {
+				return nil, errors.Errorf("chunk max_chunks must be zero or positive integer, got `%s`", value)
+			}
+			opts.maxChunks = maxChunks
+		default:
+			return nil, errors.Errorf("unknown chunk parameter `%s`", key)
+		}
+	}
+	return opts, nil
+}
+
+// executeChunked executes DELETE or UPDATE repeatedly with LIMIT until no rows are affected.
+// If the query already has LIMIT clause, it is used as chunk size, and size of the annotation is rejected as a conflict.
+// UPDATE is bounded by max_chunks (default 1000), because the condition may still match the updated rows. 0 means unlimited.
+// The execute hook is called once with the cumulative rows affected, and the cumulative rows affected is checked by limit after each chunk.
+func (e *Executer) executeChunked(ctx context.Context, q queryer, query string, redactedQuery string, a annotation, limit int64, emit func(func())) error {
+	opts, err := parseChunkAnnotation(a)
+	if err != nil {
+		return fmt.Errorf("query `%s`: %w", redactedQuery, err)
+	}
+	verb := ClassifyStatement(query).Verb
+	if verb != "DELETE" && verb != "UPDATE" {
+		return fmt.Errorf("query `%s`: chunk is only available for DELETE or UPDATE", redactedQuery)
+	}
+	if _, ok := a["max_chunks"]; !ok && verb == "UPDATE" {
+		opts.maxChunks = defaultMaxUpdateChunks
+	}
+	chunkQuery := query
+	if limitClausePattern.MatchString(query) {
+		if _, ok := a["size"]; ok {
+			return fmt.Errorf("query `%s`: chunk size conflicts with LIMIT clause", redactedQuery)
+		}
+	} else {
+		chunkQuery = fmt.Sprintf("%s LIMIT %d", query, opts.size)
+	}
+	var rowsAffected, lastInsertId int64
+	var duration time.Duration
+	var warnings []*Warning
+	for chunk := 1; ; chunk++ {
+		start := time.Now()
+		result, err := q.ExecContext(ctx, chunkQuery)
+		if err != nil {
+			return fmt.Errorf("execute query `%s` failed at chunk %d: %w", redactedQuery, chunk, err)
+		}
+		duration += time.Since(start)
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if lastInsertId, err = result.LastInsertId(); err != nil {
+			return err
+		}
+		rowsAffected += n
+		chunkWarnings, err := e.fetchWarnings(ctx, q, query)
+		if err != nil {
+			return err
+		}
+		warnings = append(warnings, chunkWarnings...)
+		if err := e.checkAffectedRows(ctx, q, redactedQuery, rowsAffected, limit); err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if opts.maxChunks > 0 && chunk >= opts.maxChunks {
+			return fmt.Errorf("execute query `%s` stopped after %d rows affected: still affecting rows after %d chunks, the condition may match the updated rows", redactedQuery, rowsAffected, chunk)
+		}
+		if err := sleepContext(ctx, opts.sleep); err != nil {
+			return fmt.Errorf("execute query `%s` canceled after %d rows affected: %w", redactedQuery, rowsAffected, err)
+		}
+		if err := e.waitForReplicaLag(ctx); err != nil {
+			return fmt.Errorf("execute query `%s` stopped after %d rows affected: %w", redactedQuery, rowsAffected, err)
+		}
+	}
+	e.current.addStatement(redactedQuery, rowsAffected)
+	e.reportExecuteResult(emit, &ExecuteResult{
+		Query:        redactedQuery,
+		Kind:         ClassifyStatement(query).Kind,
+		RowsAffected: rowsAffected,
+		LastInsertID: lastInsertId,
+		Duration:     duration,
+	})
+	return e.reportWarnings(redactedQuery, warnings, emit)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			return nil
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
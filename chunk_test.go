@@ -0,0 +1,107 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+const testChunkSetupSQL = `
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+USE mysqlbatch;
+DROP TABLE IF EXISTS chunk_events;
+CREATE TABLE chunk_events (
+    id INTEGER auto_increment,
+    created_at DATE,
+    PRIMARY KEY (id)
+);
+{%- for i in range(25) %}
+INSERT INTO chunk_events(created_at) VALUES ('2022-12-31');
+{%- endfor %}
+INSERT INTO chunk_events(created_at) VALUES ('2023-01-02');
+`
+
+func TestExecuterExecute__WithChunk(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(testChunkSetupSQL), nil))
+
+	var queries []string
+	var rowsAffected []int64
+	e.SetExecuteHook(func(query string, n, _ int64) {
+		queries = append(queries, query)
+		rowsAffected = append(rowsAffected, n)
+	})
+	err = e.Execute(strings.NewReader(`
+USE mysqlbatch;
+-- mysqlbatch:chunk size=10 sleep=10ms
+DELETE FROM chunk_events WHERE created_at < '2023-01-01';
+`), nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"USE mysqlbatch", "DELETE FROM chunk_events WHERE created_at < '2023-01-01'"}, queries)
+	require.Equal(t, []int64{0, 25}, rowsAffected)
+}
+
+func TestExecuterExecute__WithChunkCanceled(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(testChunkSetupSQL), nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err = e.ExecuteContext(ctx, strings.NewReader(`
+USE mysqlbatch;
+/* mysqlbatch:chunk size=10 sleep=1s */
+DELETE FROM chunk_events WHERE created_at < '2023-01-01';
+`), nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Contains(t, err.Error(), "canceled after 10 rows affected")
+}
+
+func TestExecuterExecute__WithInvalidChunk(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	err = e.Execute(strings.NewReader("-- mysqlbatch:chunk size=0\nDELETE FROM mysqlbatch.chunk_events;"), nil)
+	require.EqualError(t, err, "query `DELETE FROM mysqlbatch.chunk_events`: chunk size must be positive integer, got `0`")
+	err = e.Execute(strings.NewReader("-- mysqlbatch:chunk\nINSERT INTO mysqlbatch.chunk_events(created_at) VALUES ('2023-01-01');"), nil)
+	require.EqualError(t, err, "query `INSERT INTO mysqlbatch.chunk_events(created_at) VALUES ('2023-01-01')`: chunk is only available for DELETE or UPDATE")
+	err = e.Execute(strings.NewReader("-- mysqlbatch:chunk size=10\nDELETE FROM mysqlbatch.chunk_events LIMIT 100;"), nil)
+	require.EqualError(t, err, "query `DELETE FROM mysqlbatch.chunk_events LIMIT 100`: chunk size conflicts with LIMIT clause")
+	err = e.Execute(strings.NewReader("-- mysqlbatch:chunk max_chunks=x\nDELETE FROM mysqlbatch.chunk_events;"), nil)
+	require.EqualError(t, err, "query `DELETE FROM mysqlbatch.chunk_events`: chunk max_chunks must be zero or positive integer, got `x`")
+}
+
+func TestExecuterExecute__WithChunkMaxChunks(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(testChunkSetupSQL), nil))
+
+	// the condition still matches the updated rows
+	err = e.Execute(strings.NewReader(`
+USE mysqlbatch;
+-- mysqlbatch:chunk size=10 max_chunks=3
+UPDATE chunk_events SET created_at = DATE_SUB(created_at, INTERVAL 1 DAY) WHERE created_at < '2023-01-01';
+`), nil)
+	require.EqualError(t, err, "execute query `UPDATE chunk_events SET created_at = DATE_SUB(created_at, INTERVAL 1 DAY) WHERE created_at < '2023-01-01'` stopped after 30 rows affected: still affecting rows after 3 chunks, the condition may match the updated rows")
+}
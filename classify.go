@@ -0,0 +1,98 @@
+package mysqlbatch
+
+import (
+	"strings"
+)
+
+// StatementKind is the kind of SQL statement
+type StatementKind string
+
+const (
+	StatementKindEmpty       StatementKind = "empty"
+	StatementKindSelect      StatementKind = "select"
+	StatementKindDML         StatementKind = "dml"
+	StatementKindDDL         StatementKind = "ddl"
+	StatementKindTransaction StatementKind = "transaction"
+	StatementKindSession     StatementKind = "session"
+	StatementKindCall        StatementKind = "call"
+	StatementKindOther       StatementKind = "other"
+)
+
+// StatementClass is the classification of SQL statement
+type StatementClass struct {
+	// Verb is the upper-cased first keyword, or the keyword of the statement after WITH
+	Verb string
+	Kind StatementKind
+	// ReturnsRows is true if the statement returns a result set, and should be executed by QueryContext
+	ReturnsRows bool
+}
+
+var statementKinds = map[string]StatementKind{
+	"SELECT": StatementKindSelect, "VALUES": StatementKindSelect, "TABLE": StatementKindSelect, "SHOW": StatementKindSelect,
+	"EXPLAIN": StatementKindSelect, "DESCRIBE": StatementKindSelect, "DESC": StatementKindSelect, "HELP": StatementKindSelect,
+	"INSERT": StatementKindDML, "UPDATE": StatementKindDML, "DELETE": StatementKindDML, "REPLACE": StatementKindDML,
+	"LOAD": StatementKindDML, "IMPORT": StatementKindDML,
+	"CREATE": StatementKindDDL, "ALTER": StatementKindDDL, "DROP": StatementKindDDL, "TRUNCATE": StatementKindDDL,
+	"RENAME": StatementKindDDL,
+	"BEGIN":  StatementKindTransaction, "START": StatementKindTransaction, "COMMIT": StatementKindTransaction,
+	"ROLLBACK": StatementKindTransaction, "SAVEPOINT": StatementKindTransaction, "RELEASE": StatementKindTransaction,
+	"XA":  StatementKindTransaction,
+	"USE": StatementKindSession, "SET": StatementKindSession,
+	"CALL": StatementKindCall,
+}
+
+// tableMaintenanceVerbs return a result set, though they are not select
+var tableMaintenanceVerbs = map[string]bool{
+	"CHECK": true, "CHECKSUM": true, "ANALYZE": true, "OPTIMIZE": true, "REPAIR": true,
+}
+
+// ClassifyStatement classifies the query by the lexer, skipping comments and string literals.
+// It handles WITH ... SELECT, (SELECT ...), and SELECT ... INTO which does not return rows.
+func ClassifyStatement(query string) *StatementClass {
+	if strings.HasPrefix(strings.TrimSpace(query), `\`) {
+		// client commands like \G are passed through as select for compatibility
+		return &StatementClass{Verb: `\`, Kind: StatementKindOther, ReturnsRows: true}
+	}
+	tokens := tokenize(query)
+	verb := statementVerb(tokens)
+	if verb == "" {
+		return &StatementClass{Kind: StatementKindEmpty}
+	}
+	class := &StatementClass{
+		Verb: verb,
+		Kind: StatementKindOther,
+	}
+	if kind, ok := statementKinds[verb]; ok {
+		class.Kind = kind
+	}
+	switch {
+	case class.Kind == StatementKindSelect:
+		class.ReturnsRows = verb != "SELECT" || !hasIntoClause(tokens)
+	case tableMaintenanceVerbs[verb]:
+		class.ReturnsRows = true
+	}
+	return class
+}
+
+// IsSelectStatement returns true if the query returns a result set. It is the default function deciding whether to execute in QueryContext.
+func IsSelectStatement(query string) bool {
+	return ClassifyStatement(query).ReturnsRows
+}
+
+// hasIntoClause returns true if SELECT has INTO @var, OUTFILE or DUMPFILE at the top level
+func hasIntoClause(tokens []token) bool {
+	// the depth of the statement in leading parentheses
+	depth := 0
+	for _, t := range tokens {
+		if t.text != "(" {
+			depth = t.depth
+			break
+		}
+	}
+	for _, t := range tokens {
+		if t.depth == depth && t.keyword() == "INTO" {
+			return true
+		}
+	}
+	return false
+}
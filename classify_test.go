@@ -0,0 +1,68 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyStatement(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected mysqlbatch.StatementClass
+	}{
+		{query: "SELECT 1", expected: mysqlbatch.StatementClass{Verb: "SELECT", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "  select\n1", expected: mysqlbatch.StatementClass{Verb: "SELECT", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "/* report */ -- daily\nSELECT 1", expected: mysqlbatch.StatementClass{Verb: "SELECT", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "(SELECT 1) UNION (SELECT 2)", expected: mysqlbatch.StatementClass{Verb: "SELECT", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "WITH t AS (SELECT 1) SELECT * FROM t", expected: mysqlbatch.StatementClass{Verb: "SELECT", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "WITH t AS (SELECT 1) DELETE FROM users WHERE id IN (SELECT * FROM t)", expected: mysqlbatch.StatementClass{Verb: "DELETE", Kind: mysqlbatch.StatementKindDML}},
+		{query: "SELECT id INTO @id FROM users LIMIT 1", expected: mysqlbatch.StatementClass{Verb: "SELECT", Kind: mysqlbatch.StatementKindSelect}},
+		{query: "SELECT 'INTO' AS a, (SELECT 1) b", expected: mysqlbatch.StatementClass{Verb: "SELECT", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "EXPLAIN SELECT 1", expected: mysqlbatch.StatementClass{Verb: "EXPLAIN", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "DESCRIBE users", expected: mysqlbatch.StatementClass{Verb: "DESCRIBE", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "VALUES ROW(1, 2)", expected: mysqlbatch.StatementClass{Verb: "VALUES", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "TABLE users", expected: mysqlbatch.StatementClass{Verb: "TABLE", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "SHOW TABLES", expected: mysqlbatch.StatementClass{Verb: "SHOW", Kind: mysqlbatch.StatementKindSelect, ReturnsRows: true}},
+		{query: "ANALYZE TABLE users", expected: mysqlbatch.StatementClass{Verb: "ANALYZE", Kind: mysqlbatch.StatementKindOther, ReturnsRows: true}},
+		{query: "INSERT INTO users SELECT * FROM old_users", expected: mysqlbatch.StatementClass{Verb: "INSERT", Kind: mysqlbatch.StatementKindDML}},
+		{query: "CREATE TABLE users (id INTEGER)", expected: mysqlbatch.StatementClass{Verb: "CREATE", Kind: mysqlbatch.StatementKindDDL}},
+		{query: "START TRANSACTION", expected: mysqlbatch.StatementClass{Verb: "START", Kind: mysqlbatch.StatementKindTransaction}},
+		{query: "SET @a = 1", expected: mysqlbatch.StatementClass{Verb: "SET", Kind: mysqlbatch.StatementKindSession}},
+		{query: "CALL proc()", expected: mysqlbatch.StatementClass{Verb: "CALL", Kind: mysqlbatch.StatementKindCall}},
+		{query: "-- only comment", expected: mysqlbatch.StatementClass{Kind: mysqlbatch.StatementKindEmpty}},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			require.Equal(t, &c.expected, mysqlbatch.ClassifyStatement(c.query))
+		})
+	}
+}
+
+func TestExecuterExecute__SelectHookWithClassifier(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	var queries []string
+	e.SetSelectHook(func(query string, _ []string, _ [][]string) {
+		queries = append(queries, query)
+	})
+	err = e.Execute(strings.NewReader(`
+WITH t AS (SELECT 1 AS a) SELECT * FROM t;
+SELECT 3 INTO @a;
+-- comment
+SELECT @a;
+`), nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"WITH t AS (SELECT 1 AS a) SELECT * FROM t",
+		"SELECT @a",
+	}, queries)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/go-sql-driver/mysql"
+	"github.com/mashiike/mysqlbatch"
+)
+
+var errConnection = errors.New("connection failed")
+
+// errorType of the Lambda error response. These names are stable for Retry and Catch of Step Functions.
+const (
+	errorTypeConnection = "ConnectionError"
+	errorTypeTemplate   = "TemplateError"
+	errorTypeStatement  = "StatementError"
+	errorTypeTimeout    = "TimeoutError"
+	errorTypePolicy     = "PolicyError"
+)
+
+// MySQL server error codes classified as connection or timeout errors
+var (
+	connectionErrorCodes = map[uint16]bool{
+		1040: true, // ER_CON_COUNT_ERROR
+		1044: true, // ER_DBACCESS_DENIED_ERROR
+		1045: true, // ER_ACCESS_DENIED_ERROR
+		1049: true, // ER_BAD_DB_ERROR
+		1053: true, // ER_SERVER_SHUTDOWN
+		1129: true, // ER_HOST_IS_BLOCKED
+		1130: true, // ER_HOST_NOT_PRIVILEGED
+		1203: true, // ER_TOO_MANY_USER_CONNECTIONS
+	}
+	timeoutErrorCodes = map[uint16]bool{
+		1205: true, // ER_LOCK_WAIT_TIMEOUT
+		3024: true, // ER_QUERY_TIMEOUT
+	}
+)
+
+// errorMessage is limited within the 32,768 characters of Cause of Step Functions, with a margin for escaping
+const (
+	maxErrorMessageBytes = 30 * 1024
+	// truncatedRows is the rows kept per result set in the truncated partial results
+	truncatedRows = 10
+	// truncatedTextBytes is the length kept of the message and the query if the error message is still too large
+	truncatedTextBytes = 4 * 1024
+)
+
+// errorDetail is the errorMessage of the Lambda error response in JSON
+type errorDetail struct {
+	Message        string    `json:"message"`
+	Group          string    `json:"group,omitempty"`
+	StatementIndex *int      `json:"statement_index,omitempty"`
+	Query          string    `json:"query,omitempty"`
+	MySQLErrorCode uint16    `json:"mysql_error_code,omitempty"`
+	PartialResults *response `json:"partial_results,omitempty"`
+	// PartialResultsTruncated is true if the partial results are truncated to the first rows or omitted, see PartialResultsSummary
+	PartialResultsTruncated bool            `json:"partial_results_truncated,omitempty"`
+	PartialResultsSummary   *resultsSummary `json:"partial_results_summary,omitempty"`
+}
+
+// resultsSummary is the counts of the results before truncated
+type resultsSummary struct {
+	QueryResults  int `json:"query_results"`
+	Rows          int `json:"rows"`
+	GroupResults  int `json:"group_results"`
+	ExecResults   int `json:"exec_results"`
+	JobResults    int `json:"job_results"`
+	Warnings      int `json:"warnings"`
+	Plans         int `json:"plans"`
+	OutputResults int `json:"output_results"`
+}
+
+// marshal returns the JSON of the detail within maxErrorMessageBytes.
+// If too large, the partial results are truncated to the first rows, then omitted, and then the message and the query are truncated.
+func (d *errorDetail) marshal() ([]byte, error) {
+	bs, err := json.Marshal(d)
+	if err != nil || len(bs) <= maxErrorMessageBytes {
+		return bs, err
+	}
+	if d.PartialResults != nil {
+		d.PartialResultsTruncated = true
+		d.PartialResultsSummary = summarizeResults(d.PartialResults)
+		d.PartialResults = truncateResults(d.PartialResults)
+		if bs, err = json.Marshal(d); err != nil || len(bs) <= maxErrorMessageBytes {
+			return bs, err
+		}
+		d.PartialResults = nil
+		if bs, err = json.Marshal(d); err != nil || len(bs) <= maxErrorMessageBytes {
+			return bs, err
+		}
+	}
+	d.Message = truncateText(d.Message)
+	d.Query = truncateText(d.Query)
+	return json.Marshal(d)
+}
+
+// summarizeResults counts the results including the results of jobs
+func summarizeResults(r *response) *resultsSummary {
+	s := &resultsSummary{
+		QueryResults:  len(r.QueryResults),
+		GroupResults:  len(r.GroupResults),
+		ExecResults:   len(r.ExecResults),
+		JobResults:    len(r.JobResults),
+		Warnings:      len(r.Warnings),
+		Plans:         len(r.Plans),
+		OutputResults: len(r.OutputResults),
+	}
+	for _, qr := range r.QueryResults {
+		s.Rows += len(qr.Rows)
+	}
+	for _, job := range r.JobResults {
+		if job.response == nil {
+			continue
+		}
+		js := summarizeResults(job.response)
+		s.QueryResults += js.QueryResults
+		s.Rows += js.Rows
+		s.GroupResults += js.GroupResults
+		s.ExecResults += js.ExecResults
+		s.Warnings += js.Warnings
+		s.Plans += js.Plans
+		s.OutputResults += js.OutputResults
+	}
+	return s
+}
+
+// truncateResults returns the copy of r keeping the first truncatedRows rows of each result set
+func truncateResults(r *response) *response {
+	t := *r
+	t.QueryResults = make([]queryResults, len(r.QueryResults))
+	for i, qr := range r.QueryResults {
+		if len(qr.Rows) > truncatedRows {
+			qr.Rows = qr.Rows[:truncatedRows]
+		}
+		t.QueryResults[i] = qr
+	}
+	if len(r.JobResults) > 0 {
+		t.JobResults = make([]*jobResult, len(r.JobResults))
+		for i, job := range r.JobResults {
+			tj := *job
+			if job.response != nil {
+				tj.response = truncateResults(job.response)
+			}
+			t.JobResults[i] = &tj
+		}
+	}
+	return &t
+}
+
+// truncateText returns s within truncatedTextBytes, without breaking UTF-8 characters
+func truncateText(s string) string {
+	if len(s) <= truncatedTextBytes {
+		return s
+	}
+	i := truncatedTextBytes
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return s[:i] + "...(truncated)"
+}
+
+// newInvokeError returns the Lambda error response of err, with the results collected before the failure if any
+func newInvokeError(err error, partial *response) error {
+	errorType := classifyError(err)
+	if errorType == "" {
+		return err
+	}
+	detail := &errorDetail{
+		Message: err.Error(),
+	}
+	var groupErr *mysqlbatch.GroupError
+	if errors.As(err, &groupErr) {
+		detail.Group = groupErr.Name
+	}
+	var stmtErr *mysqlbatch.StatementError
+	if errors.As(err, &stmtErr) {
+		detail.StatementIndex = &stmtErr.Index
+		detail.Query = stmtErr.Query
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		detail.MySQLErrorCode = mysqlErr.Number
+	}
+	if partial != nil && !partial.isEmpty() {
+		detail.PartialResults = partial
+	}
+	bs, jsonErr := detail.marshal()
+	if jsonErr != nil {
+		return err
+	}
+	return messages.InvokeResponse_Error{
+		Type:    errorType,
+		Message: string(bs),
+	}
+}
+
+// isEmpty returns true if no results are collected
+func (r *response) isEmpty() bool {
+	return len(r.QueryResults) == 0 && len(r.GroupResults) == 0 && len(r.Warnings) == 0 && len(r.Plans) == 0 && len(r.OutputResults) == 0 && len(r.ExecResults) == 0 && len(r.JobResults) == 0
+}
+
+// classifyError returns the errorType of err, or empty if err is not classified
+func classifyError(err error) string {
+	var (
+		unsafeErr    *mysqlbatch.UnsafeStatementError
+		fullScanErr  *mysqlbatch.FullScanError
+		exceededErr  *mysqlbatch.AffectedRowsExceededError
+		templateErr  *mysqlbatch.TemplateError
+		mysqlErr     *mysql.MySQLError
+		netErr       net.Error
+		statementErr *mysqlbatch.StatementError
+	)
+	switch {
+	case errors.Is(err, errPolicyViolation), errors.As(err, &unsafeErr), errors.As(err, &fullScanErr), errors.As(err, &exceededErr):
+		return errorTypePolicy
+	case errors.As(err, &templateErr):
+		return errorTypeTemplate
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorTypeTimeout
+	case errors.As(err, &mysqlErr) && timeoutErrorCodes[mysqlErr.Number]:
+		return errorTypeTimeout
+	case errors.Is(err, errConnection), errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn), errors.As(err, &netErr):
+		return errorTypeConnection
+	case errors.As(err, &statementErr):
+		return errorTypeStatement
+	case errors.As(err, &mysqlErr) && connectionErrorCodes[mysqlErr.Number]:
+		// the codes are the connection errors unless returned by a statement, like USE
+		return errorTypeConnection
+	}
+	return ""
+}
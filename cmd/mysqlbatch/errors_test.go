@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/go-sql-driver/mysql"
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		errorType string
+	}{
+		{name: "policy", err: fmt.Errorf("%w: sql is not allowed, use file", errPolicyViolation), errorType: errorTypePolicy},
+		{name: "unsafe", err: &mysqlbatch.UnsafeStatementError{}, errorType: errorTypePolicy},
+		{name: "full scan", err: &mysqlbatch.FullScanError{Query: "SELECT 1", Table: "t"}, errorType: errorTypePolicy},
+		{name: "affected rows exceeded", err: &mysqlbatch.AffectedRowsExceededError{Query: "DELETE FROM t"}, errorType: errorTypePolicy},
+		{name: "template", err: &mysqlbatch.TemplateError{Err: errors.New("parse failed")}, errorType: errorTypeTemplate},
+		{name: "deadline", err: &mysqlbatch.StatementError{Query: "SELECT SLEEP(10)", Err: context.DeadlineExceeded}, errorType: errorTypeTimeout},
+		{name: "lock wait timeout", err: &mysqlbatch.StatementError{Err: &mysql.MySQLError{Number: 1205}}, errorType: errorTypeTimeout},
+		{name: "max execution time", err: &mysql.MySQLError{Number: 3024}, errorType: errorTypeTimeout},
+		{name: "connection", err: fmt.Errorf("%w: %w", errConnection, errors.New("dial failed")), errorType: errorTypeConnection},
+		{name: "invalid connection", err: &mysqlbatch.StatementError{Err: mysql.ErrInvalidConn}, errorType: errorTypeConnection},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("refused")}, errorType: errorTypeConnection},
+		{name: "access denied", err: &mysql.MySQLError{Number: 1045}, errorType: errorTypeConnection},
+		{name: "bad database in statement", err: &mysqlbatch.StatementError{Query: "USE unknown", Err: &mysql.MySQLError{Number: 1049}}, errorType: errorTypeStatement},
+		{name: "duplicate entry", err: &mysqlbatch.GroupError{Name: "g", Err: &mysqlbatch.StatementError{Err: &mysql.MySQLError{Number: 1062}}}, errorType: errorTypeStatement},
+		{name: "unclassified", err: errors.New("nothing todo")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.errorType, classifyError(c.err))
+			invokeErr := newInvokeError(c.err, nil)
+			if c.errorType == "" {
+				require.Equal(t, c.err, invokeErr)
+				return
+			}
+			var resp messages.InvokeResponse_Error
+			require.True(t, errors.As(invokeErr, &resp))
+			require.Equal(t, c.errorType, resp.Type)
+			var detail errorDetail
+			require.NoError(t, json.Unmarshal([]byte(resp.Message), &detail))
+			require.Equal(t, c.err.Error(), detail.Message)
+		})
+	}
+}
+
+func TestNewInvokeError__Detail(t *testing.T) {
+	err := &mysqlbatch.GroupError{Name: "users", Err: &mysqlbatch.StatementError{
+		Index: 1,
+		Query: "INSERT INTO users VALUES (1)",
+		Err:   &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"},
+	}}
+	partial := &response{QueryResults: []queryResults{{Columns: []string{"id"}, Rows: [][]string{{"1"}}, Query: "SELECT 1"}}}
+	var resp messages.InvokeResponse_Error
+	require.True(t, errors.As(newInvokeError(err, partial), &resp))
+	var detail errorDetail
+	require.NoError(t, json.Unmarshal([]byte(resp.Message), &detail))
+	require.Equal(t, "users", detail.Group)
+	require.Equal(t, 1, *detail.StatementIndex)
+	require.Equal(t, "INSERT INTO users VALUES (1)", detail.Query)
+	require.EqualValues(t, 1062, detail.MySQLErrorCode)
+	require.False(t, detail.PartialResultsTruncated)
+	require.Len(t, detail.PartialResults.QueryResults, 1)
+}
+
+func TestNewInvokeError__Truncated(t *testing.T) {
+	rows := make([][]string, 1000)
+	for i := range rows {
+		rows[i] = []string{fmt.Sprint(i), strings.Repeat("x", 100)}
+	}
+	err := &mysqlbatch.StatementError{Query: "INSERT INTO t VALUES (1)", Err: &mysql.MySQLError{Number: 1062}}
+	cases := []struct {
+		name    string
+		partial *response
+		rows    int
+	}{
+		{
+			name:    "rows",
+			partial: &response{QueryResults: []queryResults{{Columns: []string{"id", "v"}, Rows: rows}}},
+			rows:    truncatedRows,
+		},
+		{
+			name: "jobs",
+			partial: &response{JobResults: []*jobResult{
+				{Name: "a", response: &response{QueryResults: []queryResults{{Rows: rows}, {Rows: rows}}}},
+				{Name: "b", Canceled: true},
+			}},
+			rows: truncatedRows * 2,
+		},
+		{
+			name:    "omitted",
+			partial: &response{ExecResults: make([]execResult, 1000)},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			summary := summarizeResults(c.partial)
+			var resp messages.InvokeResponse_Error
+			require.True(t, errors.As(newInvokeError(err, c.partial), &resp))
+			require.LessOrEqual(t, len(resp.Message), maxErrorMessageBytes)
+			var detail struct {
+				PartialResults          json.RawMessage `json:"partial_results"`
+				PartialResultsTruncated bool            `json:"partial_results_truncated"`
+				PartialResultsSummary   *resultsSummary `json:"partial_results_summary"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(resp.Message), &detail))
+			require.True(t, detail.PartialResultsTruncated)
+			require.Equal(t, summary, detail.PartialResultsSummary)
+			if c.rows == 0 {
+				require.Nil(t, detail.PartialResults)
+				return
+			}
+			require.Equal(t, c.rows, strings.Count(string(detail.PartialResults), strings.Repeat("x", 100)))
+		})
+	}
+	require.Len(t, rows, 1000, "partial results are not modified")
+
+	long := &mysqlbatch.StatementError{Query: strings.Repeat("SELECT 1;", 10000), Err: errors.New(strings.Repeat("failed ", 10000))}
+	var resp messages.InvokeResponse_Error
+	require.True(t, errors.As(newInvokeError(&mysqlbatch.GroupError{Name: "g", Err: long}, nil), &resp))
+	require.LessOrEqual(t, len(resp.Message), maxErrorMessageBytes)
+}
+
+func TestHandlerInvoke__DeadlineMargin(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	h := &handler{
+		conf:           conf,
+		opts:           &executerOptions{parallelism: 1, lockMode: "fail"},
+		redact:         &redactOptions{},
+		deadlineMargin: 2 * time.Second,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := h.Invoke(ctx, &payload{SQL: "SELECT 1;\nSELECT SLEEP(5);"})
+	require.Less(t, time.Since(start), 2*time.Second)
+	var resp messages.InvokeResponse_Error
+	require.True(t, errors.As(err, &resp), "unexpected error: %v", err)
+	require.Equal(t, errorTypeTimeout, resp.Type)
+	var detail errorDetail
+	require.NoError(t, json.Unmarshal([]byte(resp.Message), &detail))
+	require.NotNil(t, detail.PartialResults)
+	require.Len(t, detail.PartialResults.QueryResults, 1)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// jobPayload is a job of the payload with `jobs`. Other keys of the payload are shared by all jobs.
+type jobPayload struct {
+	Name          string            `json:"name,omitempty"`
+	SQL           string            `json:"sql,omitempty"`
+	File          string            `json:"file,omitempty"`
+	FileVersionID string            `json:"file_version_id,omitempty"`
+	FilePrefix    string            `json:"file_prefix,omitempty"`
+	Vars          map[string]string `json:"vars,omitempty"`
+	Database      *string           `json:"database,omitempty"`
+}
+
+type jobResult struct {
+	Name         string  `json:"name"`
+	DurationSecs float64 `json:"duration_secs"`
+	Error        string  `json:"error,omitempty"`
+	ErrorType    string  `json:"error_type,omitempty"`
+	// Canceled is true if the job was not started because a previous job failed
+	Canceled bool `json:"canceled,omitempty"`
+	*response
+}
+
+// invokeJobs executes the jobs with the limited concurrency, and returns the results in the order of jobs.
+// Unless continue_on_error, jobs not started yet are canceled after a job fails, and the first error is returned.
+func (h *handler) invokeJobs(ctx context.Context, p *payload) (*response, error) {
+	if p.SQL != "" || p.File != "" || p.FilePrefix != "" || p.Migrate != nil {
+		return nil, fmt.Errorf("jobs are exclusive with sql, file, file_prefix and migrate")
+	}
+	payloads := make([]*payload, len(p.Jobs))
+	seen := make(map[string]bool, len(p.Jobs))
+	for i, job := range p.Jobs {
+		name := job.Name
+		if name == "" {
+			name = fmt.Sprintf("job%d", i+1)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("job name `%s` is duplicated", name)
+		}
+		seen[name] = true
+		jp, err := p.forJob(name, job)
+		if err != nil {
+			return nil, err
+		}
+		payloads[i] = jp
+	}
+	concurrency := p.JobConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var mu sync.Mutex
+	var firstErr error
+	results := make([]*jobResult, len(payloads))
+	var eg errgroup.Group
+	eg.SetLimit(concurrency)
+	for i, jp := range payloads {
+		eg.Go(func() error {
+			mu.Lock()
+			canceled := firstErr != nil && !p.ContinueOnError
+			mu.Unlock()
+			if canceled {
+				results[i] = &jobResult{Name: jp.job, Canceled: true}
+				return nil
+			}
+			start := time.Now()
+			r, err := h.invoke(ctx, jp)
+			result := &jobResult{
+				Name:         jp.job,
+				DurationSecs: time.Since(start).Seconds(),
+				response:     r,
+			}
+			if err != nil {
+				result.Error = err.Error()
+				result.ErrorType = classifyError(err)
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("job %s failed: %w", jp.job, err)
+				}
+				mu.Unlock()
+			}
+			results[i] = result
+			return nil
+		})
+	}
+	eg.Wait()
+	r := &response{JobResults: results}
+	for _, result := range results {
+		if result.response != nil && result.LastExecuteTime.After(r.LastExecuteTime) {
+			r.LastExecuteTime = result.LastExecuteTime
+			r.LastExecuteUnixMilli = result.LastExecuteUnixMilli
+		}
+	}
+	if firstErr != nil && !p.ContinueOnError {
+		return r, firstErr
+	}
+	return r, nil
+}
+
+// forJob returns the payload executing the job, sharing the other keys.
+// The job name is used as job_name, prefixed by job_name of the payload if set.
+func (p *payload) forJob(name string, job *jobPayload) (*payload, error) {
+	jp := *p
+	jp.Jobs = nil
+	jp.job = name
+	jp.SQL = job.SQL
+	jp.File = job.File
+	jp.FileVersionID = job.FileVersionID
+	jp.FilePrefix = job.FilePrefix
+	jp.Vars = make(map[string]string, len(p.Vars)+len(job.Vars))
+	for k, v := range p.Vars {
+		jp.Vars[k] = v
+	}
+	for k, v := range job.Vars {
+		jp.Vars[k] = v
+	}
+	jobName := name
+	if p.JobName != nil {
+		jobName = *p.JobName + "/" + name
+	}
+	jp.JobName = &jobName
+	if job.Database != nil {
+		config := make(map[string]json.RawMessage)
+		if len(p.config) > 0 {
+			if err := json.Unmarshal(p.config, &config); err != nil {
+				return nil, err
+			}
+		}
+		bs, err := json.Marshal(*job.Database)
+		if err != nil {
+			return nil, err
+		}
+		config["database"] = bs
+		if jp.config, err = json.Marshal(config); err != nil {
+			return nil, err
+		}
+	}
+	return &jp, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHandler() *handler {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	return &handler{
+		conf:   conf,
+		opts:   &executerOptions{parallelism: 1, lockMode: "fail"},
+		redact: &redactOptions{},
+	}
+}
+
+func invokeTestPayload(t *testing.T, h *handler, input string) (*response, error) {
+	t.Helper()
+	var p payload
+	require.NoError(t, json.Unmarshal([]byte(input), &p))
+	if len(p.Jobs) > 0 {
+		return h.invokeJobs(context.Background(), &p)
+	}
+	return h.invoke(context.Background(), &p)
+}
+
+// jobValues returns the first column of the first row of each job, or the error or canceled
+func jobValues(r *response) map[string]string {
+	values := make(map[string]string, len(r.JobResults))
+	for _, job := range r.JobResults {
+		switch {
+		case job.Canceled:
+			values[job.Name] = "canceled"
+		case job.Error != "":
+			values[job.Name] = job.ErrorType
+		default:
+			values[job.Name] = job.QueryResults[0].Rows[0][0]
+		}
+	}
+	return values
+}
+
+func jobNames(r *response) []string {
+	names := make([]string, 0, len(r.JobResults))
+	for _, job := range r.JobResults {
+		names = append(names, job.Name)
+	}
+	return names
+}
+
+func TestHandlerInvokeJobs__Order(t *testing.T) {
+	h := newTestHandler()
+	r, err := invokeTestPayload(t, h, `{
+		"job_concurrency": 3,
+		"jobs": [
+			{"name": "slow", "sql": "SELECT SLEEP(0.5);\nSELECT 'slow';"},
+			{"sql": "SELECT 'second';"},
+			{"name": "fast", "sql": "SELECT 'fast';"}
+		]
+	}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"slow", "job2", "fast"}, jobNames(r))
+	require.Equal(t, "slow", r.JobResults[0].QueryResults[1].Rows[0][0])
+	require.Equal(t, map[string]string{"slow": "0", "job2": "second", "fast": "fast"}, jobValues(r))
+	require.False(t, r.LastExecuteTime.IsZero())
+}
+
+func TestHandlerInvokeJobs__Failure(t *testing.T) {
+	const jobs = `"jobs": [
+		{"name": "a", "sql": "SELECT 'a';"},
+		{"name": "b", "sql": "SELECT * FROM mysqlbatch_no_such_table;"},
+		{"name": "c", "sql": "SELECT 'c';"}
+	]`
+	cases := []struct {
+		name   string
+		input  string
+		values map[string]string
+		err    string
+	}{
+		{
+			name:   "cancel siblings",
+			input:  `{"job_concurrency": 1, ` + jobs + `}`,
+			values: map[string]string{"a": "a", "b": errorTypeStatement, "c": "canceled"},
+			err:    "job b failed: ",
+		},
+		{
+			name:   "continue on error",
+			input:  `{"job_concurrency": 1, "continue_on_error": true, ` + jobs + `}`,
+			values: map[string]string{"a": "a", "b": errorTypeStatement, "c": "c"},
+		},
+	}
+	h := newTestHandler()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := invokeTestPayload(t, h, c.input)
+			if c.err != "" {
+				require.ErrorContains(t, err, c.err)
+				require.Equal(t, errorTypeStatement, classifyError(err))
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, []string{"a", "b", "c"}, jobNames(r))
+			require.Equal(t, c.values, jobValues(r))
+		})
+	}
+}
+
+func TestHandlerInvokeJobs__Database(t *testing.T) {
+	h := newTestHandler()
+	_, err := invokeTestPayload(t, h, `{"sql": "CREATE DATABASE IF NOT EXISTS mysqlbatch;"}`)
+	require.NoError(t, err)
+	r, err := invokeTestPayload(t, h, `{
+		"database": "information_schema",
+		"jobs": [
+			{"name": "shared", "sql": "SELECT DATABASE();"},
+			{"name": "override", "sql": "SELECT DATABASE();", "database": "mysqlbatch"}
+		]
+	}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"shared": "information_schema", "override": "mysqlbatch"}, jobValues(r))
+}
+
+func TestHandlerInvokeJobs__Invalid(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "duplicated name",
+			input: `{"jobs": [{"name": "a", "sql": "SELECT 1;"}, {"name": "a", "sql": "SELECT 2;"}]}`,
+			err:   "job name `a` is duplicated",
+		},
+		{
+			name:  "duplicated default name",
+			input: `{"jobs": [{"sql": "SELECT 1;"}, {"name": "job1", "sql": "SELECT 2;"}]}`,
+			err:   "job name `job1` is duplicated",
+		},
+		{
+			name:  "exclusive with sql",
+			input: `{"sql": "SELECT 1;", "jobs": [{"sql": "SELECT 2;"}]}`,
+			err:   "jobs are exclusive with sql, file, file_prefix and migrate",
+		},
+	}
+	h := newTestHandler()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var p payload
+			require.NoError(t, json.Unmarshal([]byte(c.input), &p))
+			r, err := h.invokeJobs(context.Background(), &p)
+			require.EqualError(t, err, c.err)
+			require.Nil(t, r)
+		})
+	}
+}
+
+func TestPayloadForJob(t *testing.T) {
+	var p payload
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"vars": {"a": "1", "b": "2"},
+		"job_name": "nightly",
+		"host": "db.example.com",
+		"database": "app",
+		"jobs": [{"name": "report", "sql": "SELECT 1;", "vars": {"b": "3", "c": "4"}, "database": "report"}]
+	}`), &p))
+	jp, err := p.forJob("report", p.Jobs[0])
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "1", "b": "3", "c": "4"}, jp.Vars)
+	require.Equal(t, map[string]string{"a": "1", "b": "2"}, p.Vars, "shared vars are not modified")
+	require.Equal(t, "nightly/report", *jp.JobName)
+	require.Equal(t, "nightly", *p.JobName)
+	require.Equal(t, "report", jp.job)
+	require.Equal(t, "SELECT 1;", jp.SQL)
+	require.Nil(t, jp.Jobs)
+
+	conf, err := jp.applyConfig(mysqlbatch.NewDefaultConfig())
+	require.NoError(t, err)
+	require.Equal(t, "report", conf.Database)
+	require.Equal(t, "db.example.com", conf.Host)
+	conf, err = p.applyConfig(mysqlbatch.NewDefaultConfig())
+	require.NoError(t, err)
+	require.Equal(t, "app", conf.Database, "shared database is not modified")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/olekukonko/tablewriter"
+)
+
+type migrateOptions struct {
+	command string
+	n       int
+	dir     string
+	table   string
+}
+
+// parseMigrateArgs parses `migrate [-dir migrations] [-table schema_migrations] [-database db] status|up [N]|down [N]`
+func parseMigrateArgs(args []string, conf *mysqlbatch.Config) (*migrateOptions, error) {
+	opts := &migrateOptions{}
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.StringVar(&opts.dir, "dir", "migrations", "directory of migration files")
+	fs.StringVar(&opts.table, "table", mysqlbatch.DefaultMigrationTable, "table tracking applied migrations")
+	fs.StringVar(&conf.Database, "database", conf.Database, "database name")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: mysqlbatch [options] migrate [-dir migrations] [-table schema_migrations] [-database db] status|up [N]|down [N]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() == 0 || fs.NArg() > 2 {
+		fs.Usage()
+		return nil, fmt.Errorf("invalid migrate arguments")
+	}
+	opts.command = fs.Arg(0)
+	switch opts.command {
+	case "status", "up", "down":
+	default:
+		return nil, fmt.Errorf("unknown migrate command `%s`", opts.command)
+	}
+	if fs.NArg() == 2 {
+		if opts.command == "status" {
+			return nil, fmt.Errorf("migrate status does not take N")
+		}
+		n, err := strconv.Atoi(fs.Arg(1))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid N `%s`, must be positive integer", fs.Arg(1))
+		}
+		opts.n = n
+	}
+	return opts, nil
+}
+
+// runMigrate runs the migrate command, and returns the migration status after the command
+func runMigrate(ctx context.Context, e *mysqlbatch.Executer, opts *migrateOptions, vars map[string]string) ([]*mysqlbatch.MigrationStatus, error) {
+	m := mysqlbatch.NewMigrator(e, os.DirFS(opts.dir))
+	if opts.table != "" {
+		m.SetTable(opts.table)
+	}
+	switch opts.command {
+	case "up":
+		applied, err := m.Up(ctx, opts.n, vars)
+		for _, mig := range applied {
+			log.Printf("migration %d_%s applied", mig.Version, mig.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(applied) == 0 {
+			log.Println("no pending migrations")
+		}
+	case "down":
+		rolledBack, err := m.Down(ctx, opts.n, vars)
+		for _, mig := range rolledBack {
+			log.Printf("migration %d_%s rolled back", mig.Version, mig.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rolledBack) == 0 {
+			log.Println("no applied migrations")
+		}
+	}
+	return m.Status(ctx)
+}
+
+func printMigrationStatus(w io.Writer, statuses []*mysqlbatch.MigrationStatus) {
+	tw := tablewriter.NewWriter(w)
+	tw.SetHeader([]string{"version", "name", "applied", "applied at", "note"})
+	tw.SetAutoWrapText(false)
+	for _, status := range statuses {
+		var appliedAt, note string
+		if status.Applied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		switch {
+		case status.Missing:
+			note = "file is missing"
+		case status.Modified:
+			note = "modified after applied"
+		}
+		tw.Append([]string{
+			strconv.FormatInt(status.Version, 10),
+			status.Name,
+			strconv.FormatBool(status.Applied),
+			appliedAt,
+			note,
+		})
+	}
+	tw.Render()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mashiike/mysqlbatch"
+)
+
+var (
+	payloadKeys = jsonKeys(reflect.TypeOf(payload{}))
+	configKeys  = jsonKeys(reflect.TypeOf(mysqlbatch.Config{}))
+)
+
+// jsonKeys returns the JSON keys of the struct fields
+func jsonKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+// UnmarshalJSON decodes the payload, rejecting unknown keys.
+// The keys of mysqlbatch.Config are kept to override the connection settings of the function.
+func (p *payload) UnmarshalJSON(bs []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(bs, &fields); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	// keys are matched case-insensitively like encoding/json, e.g. `SQL` and `Location`
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := canonicalKey(name)
+		if key == "" || key == name {
+			continue
+		}
+		if _, ok := fields[key]; ok {
+			return fmt.Errorf("invalid payload: `%s` and `%s` are exclusive, use `%s`", name, key, key)
+		}
+		fields[key] = fields[name]
+		delete(fields, name)
+	}
+	var unknown []string
+	config := make(map[string]json.RawMessage)
+	for key, value := range fields {
+		switch {
+		case configKeys[key]:
+			config[key] = value
+			delete(fields, key)
+		case !payloadKeys[key]:
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		msgs := make([]string, 0, len(unknown))
+		for _, key := range unknown {
+			msg := fmt.Sprintf("unknown key `%s`", key)
+			if suggestion := suggestKey(key); suggestion != "" {
+				msg += fmt.Sprintf(", did you mean `%s`?", suggestion)
+			}
+			msgs = append(msgs, msg)
+		}
+		return fmt.Errorf("invalid payload: %s", strings.Join(msgs, "; "))
+	}
+	// type without UnmarshalJSON
+	type rawPayload payload
+	if err := decodeStrict(fields, (*rawPayload)(p)); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	if len(config) > 0 {
+		if err := decodeStrict(config, &mysqlbatch.Config{}); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		p.config, _ = json.Marshal(config)
+	}
+	return nil
+}
+
+// canonicalKey returns the known key matching name case-insensitively, or empty if no key matches
+func canonicalKey(name string) string {
+	if payloadKeys[name] || configKeys[name] {
+		return name
+	}
+	for _, keys := range []map[string]bool{payloadKeys, configKeys} {
+		for key := range keys {
+			if strings.EqualFold(name, key) {
+				return key
+			}
+		}
+	}
+	return ""
+}
+
+// decodeStrict decodes fields into v, rejecting unknown keys of nested objects
+func decodeStrict(fields map[string]json.RawMessage, v interface{}) error {
+	bs, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// suggestKey returns the known key closest to the unknown key, or empty if no key is close
+func suggestKey(key string) string {
+	known := make([]string, 0, len(payloadKeys)+len(configKeys))
+	for _, keys := range []map[string]bool{payloadKeys, configKeys} {
+		for k := range keys {
+			known = append(known, k)
+		}
+	}
+	sort.Strings(known)
+	normalized := strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+	best, bestDistance := "", 3
+	for _, k := range known {
+		if d := editDistance(normalized, k); d < bestDistance {
+			best, bestDistance = k, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance of a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// applyConfig returns the copy of base overridden by the keys of mysqlbatch.Config in the payload
+func (p *payload) applyConfig(base *mysqlbatch.Config) (mysqlbatch.Config, error) {
+	conf := *base
+	if len(p.config) == 0 {
+		return conf, nil
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(p.config, &keys); err != nil {
+		return conf, err
+	}
+	_, hasPassword := keys["password"]
+	_, hasParameter := keys["password_ssm_parameter_name"]
+	if hasParameter && !hasPassword {
+		// the password of the function takes precedence over the parameter otherwise
+		conf.Password = ""
+	}
+	if err := json.Unmarshal(p.config, &conf); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayloadUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		name   string
+		input  string
+		expect func(t *testing.T, p *payload)
+		// err is the prefix of the error message
+		err string
+	}{
+		{
+			name:  "payload keys",
+			input: `{"sql":"SELECT 1","vars":{"a":"1"},"parallelism":2,"max_replica_lag":"30s","lock_timeout":5,"migrate":{"command":"up","n":1}}`,
+			expect: func(t *testing.T, p *payload) {
+				require.Equal(t, "SELECT 1", p.SQL)
+				require.Equal(t, map[string]string{"a": "1"}, p.Vars)
+				require.Equal(t, 2, *p.Parallelism)
+				require.Equal(t, duration(30*time.Second), *p.MaxReplicaLag)
+				require.Equal(t, duration(5*time.Second), *p.LockTimeout)
+				require.Equal(t, &migratePayload{Command: "up", N: 1}, p.Migrate)
+				require.Nil(t, p.config)
+			},
+		},
+		{
+			name:  "config keys",
+			input: `{"sql":"SELECT 1","host":"db.example.com","database":"app"}`,
+			expect: func(t *testing.T, p *payload) {
+				require.JSONEq(t, `{"host":"db.example.com","database":"app"}`, string(p.config))
+			},
+		},
+		{
+			name:  "alias key",
+			input: `{"sql":"SELECT 1","Location":"Asia/Tokyo"}`,
+			expect: func(t *testing.T, p *payload) {
+				require.JSONEq(t, `{"location":"Asia/Tokyo"}`, string(p.config))
+			},
+		},
+		{
+			name:  "alias and key",
+			input: `{"Location":"Asia/Tokyo","location":"UTC"}`,
+			err:   "invalid payload: `Location` and `location` are exclusive, use `location`",
+		},
+		{
+			name:  "case-insensitive keys",
+			input: `{"SQL":"SELECT 1","Vars":{"k":"v"},"Job_Name":"report","DSN":"root@tcp(127.0.0.1:3306)/app"}`,
+			expect: func(t *testing.T, p *payload) {
+				require.Equal(t, "SELECT 1", p.SQL)
+				require.Equal(t, map[string]string{"k": "v"}, p.Vars)
+				require.Equal(t, "report", *p.JobName)
+				require.JSONEq(t, `{"dsn":"root@tcp(127.0.0.1:3306)/app"}`, string(p.config))
+			},
+		},
+		{
+			name:  "case-insensitive file key",
+			input: `{"File":"s3://example-bucket/batch.sql"}`,
+			expect: func(t *testing.T, p *payload) {
+				require.Equal(t, "s3://example-bucket/batch.sql", p.File)
+			},
+		},
+		{
+			name:  "case-insensitive key and key",
+			input: `{"SQL":"SELECT 1","sql":"SELECT 2"}`,
+			err:   "invalid payload: `SQL` and `sql` are exclusive, use `sql`",
+		},
+		{
+			name:  "unknown key with suggestion",
+			input: `{"sqll":"SELECT 1"}`,
+			err:   "invalid payload: unknown key `sqll`, did you mean `sql`?",
+		},
+		{
+			name:  "unknown key in kebab case",
+			input: `{"max-replica-lag":"30s"}`,
+			err:   "invalid payload: unknown key `max-replica-lag`, did you mean `max_replica_lag`?",
+		},
+		{
+			name:  "unknown keys sorted",
+			input: `{"zzz":1,"pasword":"x","something_else":true}`,
+			err:   "invalid payload: unknown key `pasword`, did you mean `password`?; unknown key `something_else`; unknown key `zzz`",
+		},
+		{
+			name:  "unknown nested key",
+			input: `{"migrate":{"command":"up","nn":1}}`,
+			err:   `invalid payload: json: unknown field "nn"`,
+		},
+		{
+			name:  "invalid type",
+			input: `{"parallelism":"2"}`,
+			err:   "invalid payload: json: cannot unmarshal string into Go struct field rawPayload.parallelism",
+		},
+		{
+			name:  "invalid config type",
+			input: `{"port":"3306"}`,
+			err:   "invalid payload: json: cannot unmarshal string into Go struct field Config.port",
+		},
+		{
+			name:  "not object",
+			input: `["SELECT 1"]`,
+			err:   "invalid payload: json: cannot unmarshal array into Go value",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var p payload
+			err := json.Unmarshal([]byte(c.input), &p)
+			if c.err != "" {
+				require.Error(t, err)
+				require.True(t, strings.HasPrefix(err.Error(), c.err), "unexpected error: %v", err)
+				return
+			}
+			require.NoError(t, err)
+			c.expect(t, &p)
+		})
+	}
+}
+
+func TestPayloadApplyConfig(t *testing.T) {
+	base := mysqlbatch.NewDefaultConfig()
+	base.Password = "function-password"
+	base.Database = "app"
+	cases := []struct {
+		name      string
+		input     string
+		password  string
+		parameter string
+		host      string
+		database  string
+	}{
+		{
+			name:     "no config keys",
+			input:    `{"sql":"SELECT 1"}`,
+			password: "function-password",
+			host:     "127.0.0.1",
+			database: "app",
+		},
+		{
+			name:     "password",
+			input:    `{"password":"payload-password","host":"db.example.com"}`,
+			password: "payload-password",
+			host:     "db.example.com",
+			database: "app",
+		},
+		{
+			name:      "ssm parameter overrides the password of the function",
+			input:     `{"password_ssm_parameter_name":"/db/password","database":"report"}`,
+			parameter: "/db/password",
+			host:      "127.0.0.1",
+			database:  "report",
+		},
+		{
+			name:      "password takes precedence over ssm parameter in the payload",
+			input:     `{"password":"payload-password","password_ssm_parameter_name":"/db/password"}`,
+			password:  "payload-password",
+			parameter: "/db/password",
+			host:      "127.0.0.1",
+			database:  "app",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var p payload
+			require.NoError(t, json.Unmarshal([]byte(c.input), &p))
+			conf, err := p.applyConfig(base)
+			require.NoError(t, err)
+			require.Equal(t, c.password, conf.Password)
+			require.Equal(t, c.parameter, conf.PasswordSSMParameterName)
+			require.Equal(t, c.host, conf.Host)
+			require.Equal(t, c.database, conf.Database)
+			require.Equal(t, "root", conf.User)
+		})
+	}
+	require.Equal(t, "function-password", base.Password, "base is not modified")
+}
+
+func TestInvoke__PayloadReadOnly(t *testing.T) {
+	h := newTestHandler()
+	h.opts.readOnly = true
+	for _, input := range []string{
+		`{"sql":"CREATE DATABASE IF NOT EXISTS mysqlbatch;"}`,
+		`{"sql":"CREATE DATABASE IF NOT EXISTS mysqlbatch;","read_only":false}`,
+	} {
+		_, err := invokeTestPayload(t, h, input)
+		var unsafeErr *mysqlbatch.UnsafeStatementError
+		require.ErrorAs(t, err, &unsafeErr, input)
+	}
+
+	h.opts.readOnly = false
+	_, err := invokeTestPayload(t, h, `{"sql":"CREATE DATABASE IF NOT EXISTS mysqlbatch;","read_only":true}`)
+	var unsafeErr *mysqlbatch.UnsafeStatementError
+	require.ErrorAs(t, err, &unsafeErr)
+}
+
+func TestInvoke__PayloadSafeUpdates(t *testing.T) {
+	h := newTestHandler()
+	h.opts.safeUpdates = true
+	for _, input := range []string{
+		`{"sql":"DROP TABLE IF EXISTS mysqlbatch.not_exists;"}`,
+		`{"sql":"DROP TABLE IF EXISTS mysqlbatch.not_exists;","safe_updates":false}`,
+	} {
+		_, err := invokeTestPayload(t, h, input)
+		var unsafeErr *mysqlbatch.UnsafeStatementError
+		require.ErrorAs(t, err, &unsafeErr, input)
+	}
+
+	h.opts.safeUpdates = false
+	_, err := invokeTestPayload(t, h, `{"sql":"DROP TABLE IF EXISTS mysqlbatch.not_exists;","safe_updates":true}`)
+	var unsafeErr *mysqlbatch.UnsafeStatementError
+	require.ErrorAs(t, err, &unsafeErr)
+}
+
+func TestInvoke__PayloadMaxAffectedRows(t *testing.T) {
+	h := newTestHandler()
+	_, err := invokeTestPayload(t, h, `{"sql":"CREATE DATABASE IF NOT EXISTS mysqlbatch; DROP TABLE IF EXISTS mysqlbatch.payload_limit; CREATE TABLE mysqlbatch.payload_limit (id INT PRIMARY KEY);"}`)
+	require.NoError(t, err)
+
+	cases := []struct {
+		server  int64
+		payload string
+		limit   int64
+	}{
+		{server: 1, payload: `,"max_affected_rows":100`, limit: 1},
+		{server: 1, payload: `,"max_affected_rows":0`, limit: 1},
+		{server: 2, payload: `,"max_affected_rows":1`, limit: 1},
+		{server: 0, payload: `,"max_affected_rows":1`, limit: 1},
+		{server: 2, payload: ``, limit: 2},
+	}
+	for _, c := range cases {
+		h.opts.maxAffectedRows = c.server
+		_, err := invokeTestPayload(t, h, `{"sql":"INSERT INTO mysqlbatch.payload_limit VALUES (1), (2), (3); DELETE FROM mysqlbatch.payload_limit WHERE id > 0;"`+c.payload+`}`)
+		var exceededErr *mysqlbatch.AffectedRowsExceededError
+		require.ErrorAs(t, err, &exceededErr, c.payload)
+		require.Equal(t, c.limit, exceededErr.Limit, c.payload)
+		_, err = invokeTestPayload(t, h, `{"sql":"TRUNCATE TABLE mysqlbatch.payload_limit;"}`)
+		require.NoError(t, err)
+	}
+}
+
+func TestTighterLimit(t *testing.T) {
+	require.Equal(t, int64(0), tighterLimit(0, 0))
+	require.Equal(t, int64(10), tighterLimit(0, 10))
+	require.Equal(t, int64(10), tighterLimit(10, 0))
+	require.Equal(t, int64(10), tighterLimit(10, 100))
+	require.Equal(t, int64(5), tighterLimit(10, 5))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mashiike/mysqlbatch"
+	"gopkg.in/yaml.v3"
+)
+
+var errPolicyViolation = errors.New("policy violation")
+
+// policyConfig is the server side policy, restricting statements and the Lambda payload
+type policyConfig struct {
+	mysqlbatch.Policy `yaml:",inline"`
+	// FileOnly rejects `sql` in the Lambda payload
+	FileOnly bool `yaml:"file_only,omitempty"`
+	// FileDirs restricts `file` and migrate `dir` in the Lambda payload within the directories.
+	// S3 URIs in `file` and `file_prefix` are restricted by s3://bucket/prefix/ entries.
+	FileDirs []string `yaml:"file_dirs,omitempty"`
+}
+
+// loadPolicy loads the policy from YAML or JSON string, or the file. Returns nil if both are empty.
+func loadPolicy(inline string, file string) (*policyConfig, error) {
+	if inline != "" && file != "" {
+		return nil, fmt.Errorf("policy and policy file are exclusive")
+	}
+	bs := []byte(inline)
+	if file != "" {
+		var err error
+		if bs, err = os.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("read policy file: %w", err)
+		}
+	}
+	if len(bytes.TrimSpace(bs)) == 0 {
+		return nil, nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(bs))
+	dec.KnownFields(true)
+	var p policyConfig
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parse policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("parse policy: %w", err)
+	}
+	return &p, nil
+}
+
+// apply set the statement policy to the executer
+func (p *policyConfig) apply(e *mysqlbatch.Executer) {
+	if p == nil {
+		return
+	}
+	e.SetPolicy(&p.Policy)
+}
+
+// checkSQL returns an error if `sql` in the payload is not allowed
+func (p *policyConfig) checkSQL() error {
+	if p != nil && p.FileOnly {
+		return fmt.Errorf("%w: sql is not allowed, use file", errPolicyViolation)
+	}
+	return nil
+}
+
+// checkPayload returns an error if the payload sets the tables written by mysqlbatch out of the table patterns, or the replica lag DSN.
+// database resolves unqualified tables.
+func (p *policyConfig) checkPayload(pl *payload, database string) error {
+	if p == nil {
+		return nil
+	}
+	if pl.ReplicaLagDSN != nil {
+		return fmt.Errorf("%w: replica_lag_dsn is not allowed in payload, use --replica-lag-dsn", errPolicyViolation)
+	}
+	tables := []struct {
+		key   string
+		table *string
+	}{
+		{"history_table", pl.HistoryTable},
+		{"checkpoint_table", pl.CheckpointTable},
+	}
+	for _, t := range tables {
+		if t.table == nil || *t.table == "" {
+			continue
+		}
+		if err := p.CheckTable(*t.table, database); err != nil {
+			return fmt.Errorf("%w: %s: %w", errPolicyViolation, t.key, err)
+		}
+	}
+	return nil
+}
+
+// checkPath returns an error if the path in the payload is out of the allowed directories
+func (p *policyConfig) checkPath(path string) error {
+	if p == nil || len(p.FileDirs) == 0 {
+		return nil
+	}
+	if mysqlbatch.IsS3URI(path) {
+		for _, dir := range p.FileDirs {
+			// the prefix ends with `/`, so that s3://bucket/prefix does not match s3://bucket/prefix-evil/
+			if mysqlbatch.IsS3URI(dir) && strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/") {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s is out of the allowed directories", errPolicyViolation, path)
+	}
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+	for _, dir := range p.FileDirs {
+		resolvedDir, err := resolvePath(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(resolvedDir, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is out of the allowed directories", errPolicyViolation, path)
+}
+
+// resolvePath returns the absolute path resolving symbolic links
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
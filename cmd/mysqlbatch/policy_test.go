@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyConfigCheckPath__S3(t *testing.T) {
+	for _, dir := range []string{"s3://example-bucket/sql", "s3://example-bucket/sql/"} {
+		p := &policyConfig{FileDirs: []string{dir}}
+		cases := []struct {
+			path    string
+			allowed bool
+		}{
+			{path: "s3://example-bucket/sql/batch.sql", allowed: true},
+			{path: "s3://example-bucket/sql/daily/", allowed: true},
+			{path: "s3://example-bucket/sql-evil/batch.sql"},
+			{path: "s3://example-bucket/sql"},
+			{path: "s3://example-bucket/sqlbatch.sql"},
+			{path: "s3://other-bucket/sql/batch.sql"},
+		}
+		for _, c := range cases {
+			t.Run(dir+" "+c.path, func(t *testing.T) {
+				err := p.checkPath(c.path)
+				if c.allowed {
+					require.NoError(t, err)
+					return
+				}
+				require.True(t, errors.Is(err, errPolicyViolation), "unexpected error: %v", err)
+			})
+		}
+	}
+}
+
+func TestPolicyConfigCheckPayload(t *testing.T) {
+	p := &policyConfig{
+		Policy: mysqlbatch.Policy{
+			AllowTables: []string{"app.*"},
+			DenyTables:  []string{"app.users"},
+		},
+	}
+	str := func(s string) *string { return &s }
+	cases := []struct {
+		name    string
+		payload payload
+		reason  string
+	}{
+		{name: "empty"},
+		{name: "allowed tables", payload: payload{HistoryTable: str("batch_history"), CheckpointTable: str("app.checkpoints")}},
+		{name: "denied history table", payload: payload{HistoryTable: str("users")}, reason: "policy violation: history_table: table app.users is denied by policy"},
+		{name: "not allowed checkpoint table", payload: payload{CheckpointTable: str("mysql.user")}, reason: "policy violation: checkpoint_table: table mysql.user is not allowed by policy"},
+		{name: "replica lag dsn", payload: payload{ReplicaLagDSN: str("root@tcp(evil:3306)/")}, reason: "policy violation: replica_lag_dsn is not allowed in payload, use --replica-lag-dsn"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := p.checkPayload(&c.payload, "app")
+			if c.reason == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, c.reason)
+		})
+	}
+	var nilPolicy *policyConfig
+	require.NoError(t, nilPolicy.checkPayload(&payload{ReplicaLagDSN: str("root@tcp(evil:3306)/")}, "app"))
+}
@@ -20,18 +20,19 @@ import (
 
 // Config is a connection setting to MySQL.
 // Exists to generate a Golang connection DSN to MySQL
+// The JSON keys are used in the Lambda payload.
 type Config struct {
-	DSN      string
-	User     string
-	Password string
-	Host     string
-	Port     int
-	Database string
-	Location string
-
-	PasswordSSMParameterName    string
-	PasswordSSMParameterJSONKey string
-	Fetcher                     *SSMParameterFetcher
+	DSN      string `json:"dsn,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Database string `json:"database,omitempty"`
+	Location string `json:"location,omitempty"`
+
+	PasswordSSMParameterName    string               `json:"password_ssm_parameter_name,omitempty"`
+	PasswordSSMParameterJSONKey string               `json:"password_ssm_parameter_json_key,omitempty"`
+	Fetcher                     *SSMParameterFetcher `json:"-"`
 }
 
 type SSMParameterFetcher struct {
@@ -86,20 +87,22 @@ func (c *Config) needToRetrievePasswordRemotely() bool {
 	return c.Password == "" && c.PasswordSSMParameterName != ""
 }
 
+// Fetch returns the value of the SSM parameter.
+// If parameterJSONKey is specified and the value is JSON, returns the value of the key.
 func (f *SSMParameterFetcher) Fetch(ctx context.Context, parameterName string, parameterJSONKey string) (string, error) {
-
-	if password, ok := f.fetchFromCache(parameterName); ok {
-		return password, nil
-	}
-	password, err := f.fetchFromRemote(ctx, parameterName, parameterJSONKey)
-	if err != nil {
-		return "", fmt.Errorf("getFromRemote: %w", err)
+	value, ok := f.fetchFromCache(parameterName)
+	if !ok {
+		var err error
+		value, err = f.fetchFromRemote(ctx, parameterName)
+		if err != nil {
+			return "", fmt.Errorf("getFromRemote: %w", err)
+		}
 	}
-	return password, nil
+	return extractJSONKey(value, parameterJSONKey)
 }
 
-func (f *SSMParameterFetcher) fetchFromRemote(ctx context.Context, parameterName string, parameterJSONKey string) (string, error) {
-	v, err, _ := f.g.Do("fetchRemote", func() (interface{}, error) {
+func (f *SSMParameterFetcher) fetchFromRemote(ctx context.Context, parameterName string) (string, error) {
+	v, err, _ := f.g.Do(parameterName, func() (interface{}, error) {
 		if f.ssmClient == nil {
 			awsConf, err := config.LoadDefaultConfig(ctx, f.LoadAWSDefaultConfigOptions...)
 			if err != nil {
@@ -118,34 +121,38 @@ func (f *SSMParameterFetcher) fetchFromRemote(ctx context.Context, parameterName
 			return nil, err
 		}
 		value := *output.Parameter.Value
-		if json.Valid([]byte(value)) && parameterJSONKey != "" {
-			log.Println("ssm parameter value is json, try to parse it")
-			var m map[string]interface{}
-			if err := json.Unmarshal([]byte(value), &m); err != nil {
-				return nil, err
-			}
-			if v, ok := m[parameterJSONKey]; ok {
-				if password, ok := v.(string); ok {
-					value = password
-				} else {
-					return nil, fmt.Errorf("ssm parameter value is json, but `%s` key is not string", parameterJSONKey)
-				}
-			} else {
-				return nil, fmt.Errorf("ssm parameter value is json, but `%s` key is not found", parameterJSONKey)
-			}
-		}
 		f.setToCache(parameterName, value)
 		return value, nil
 	})
 	if err != nil {
 		return "", err
 	}
-	if password, ok := v.(string); ok {
-		return password, nil
+	if value, ok := v.(string); ok {
+		return value, nil
 	}
 	return "", errors.New("v is not string")
 }
 
+func extractJSONKey(value string, parameterJSONKey string) (string, error) {
+	if parameterJSONKey == "" || !json.Valid([]byte(value)) {
+		return value, nil
+	}
+	log.Println("ssm parameter value is json, try to parse it")
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &m); err != nil {
+		return "", err
+	}
+	v, ok := m[parameterJSONKey]
+	if !ok {
+		return "", fmt.Errorf("ssm parameter value is json, but `%s` key is not found", parameterJSONKey)
+	}
+	str, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("ssm parameter value is json, but `%s` key is not string", parameterJSONKey)
+	}
+	return str, nil
+}
+
 var cacheTTL time.Duration = 15 * time.Minute
 
 func (f *SSMParameterFetcher) fetchFromCache(parameterName string) (string, bool) {
@@ -158,13 +165,13 @@ func (f *SSMParameterFetcher) fetchFromCache(parameterName string) (string, bool
 		if f.cachedValue == nil {
 			return "", false
 		}
-		password, ok := f.cachedValue[parameterName]
-		return password, ok
+		value, ok := f.cachedValue[parameterName]
+		return value, ok
 	}
 	return "", false
 }
 
-func (f *SSMParameterFetcher) setToCache(parameterName string, password string) {
+func (f *SSMParameterFetcher) setToCache(parameterName string, value string) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	if f.fetchedAt == nil {
@@ -174,7 +181,7 @@ func (f *SSMParameterFetcher) setToCache(parameterName string, password string)
 	if f.cachedValue == nil {
 		f.cachedValue = make(map[string]string)
 	}
-	f.cachedValue[parameterName] = password
+	f.cachedValue[parameterName] = value
 	log.Printf("cached ssm parameter `%s`,expire is %s", parameterName, f.fetchedAt[parameterName].Add(cacheTTL).Format(time.RFC3339))
 	for key, t := range f.fetchedAt {
 		if flextime.Since(t) >= cacheTTL {
@@ -183,3 +190,19 @@ func (f *SSMParameterFetcher) setToCache(parameterName string, password string)
 		}
 	}
 }
+
+// Invalidate discards the cached value of the SSM parameter, so that the next Fetch gets the latest value
+func (f *SSMParameterFetcher) Invalidate(parameterName string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.fetchedAt, parameterName)
+	delete(f.cachedValue, parameterName)
+}
+
+const secretsManagerReferencePrefix = "/aws/reference/secretsmanager/"
+
+// FetchSecret returns the value of the Secrets Manager secret through the SSM parameter reference.
+// If secretJSONKey is specified and the value is JSON, returns the value of the key.
+func (f *SSMParameterFetcher) FetchSecret(ctx context.Context, secretID string, secretJSONKey string) (string, error) {
+	return f.Fetch(ctx, secretsManagerReferencePrefix+strings.TrimPrefix(secretID, "/"), secretJSONKey)
+}
@@ -0,0 +1,261 @@
+package mysqlbatch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// DefaultDBCacheMaxIdleAge is the default of DBCache.MaxIdleAge
+const DefaultDBCacheMaxIdleAge = 5 * time.Minute
+
+// DBCache keeps DBs by the DSN to reuse connections across executions, e.g. warm invocations of Lambda.
+// A DB is used by one Executer at a time, so that executions do not wait for the connection of each other.
+// A DB is not reused after a failed execution, or a statement leaving the state on the connection like a transaction,
+// SET, a lock, a temporary table or a user variable. The database changed by USE is reset to the database of the DSN.
+type DBCache struct {
+	// MaxIdleAge is the age of DBs not used, after which they are closed instead of reused
+	MaxIdleAge time.Duration
+	// PingTimeout is the timeout of the health check before reusing a DB
+	PingTimeout time.Duration
+
+	mu   sync.Mutex
+	idle map[string][]*cachedDB
+}
+
+type cachedDB struct {
+	db       *sql.DB
+	dsn      string
+	lastUsed time.Time
+}
+
+// NewDBCache returns the DBCache closing DBs idle longer than maxIdleAge
+func NewDBCache(maxIdleAge time.Duration) *DBCache {
+	return &DBCache{
+		MaxIdleAge:  maxIdleAge,
+		PingTimeout: 5 * time.Second,
+	}
+}
+
+// New returns Executer with the cached DB of the DSN of conf, and Close of the Executer returns the DB to the cache.
+// A cached DB is pinged before reuse, and closed if the ping fails, it is idle longer than MaxIdleAge, or the password has changed.
+// If the password from the SSM parameter is rejected, the cached parameter is discarded and fetched again for the rotation.
+func (c *DBCache) New(ctx context.Context, conf *Config) (*Executer, error) {
+	db, err := c.get(ctx, conf)
+	if errors.Is(err, errAccessDenied) && conf.needToRetrievePasswordRemotely() && conf.Fetcher != nil {
+		log.Printf("access denied, fetch ssm parameter `%s` again", conf.PasswordSSMParameterName)
+		conf.Fetcher.Invalidate(conf.PasswordSSMParameterName)
+		db, err = c.get(ctx, conf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := NewWithDB(db.db)
+	if conf.Fetcher != nil {
+		e.fetcher = conf.Fetcher
+	}
+	var once sync.Once
+	e.release = func() error {
+		once.Do(func() {
+			if c.reset(db, e.sessionChange) {
+				c.put(db)
+			}
+		})
+		return nil
+	}
+	return e, nil
+}
+
+// reset resets the session changed by the executions on the DB, and returns false if the DB is closed instead
+func (c *DBCache) reset(cached *cachedDB, change sessionChange) bool {
+	switch change {
+	case sessionUnchanged:
+		return true
+	case sessionDatabaseChanged:
+		cfg, err := mysql.ParseDSN(cached.dsn)
+		if err == nil && cfg.DBName != "" {
+			ctx := context.Background()
+			if c.PingTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, c.PingTimeout)
+				defer cancel()
+			}
+			_, err = cached.db.ExecContext(ctx, "USE `"+strings.ReplaceAll(cfg.DBName, "`", "``")+"`")
+			if err == nil {
+				return true
+			}
+			log.Printf("reset database of the cached connection failed: %s", err)
+		}
+	}
+	cached.db.Close()
+	return false
+}
+
+// sessionChange is the state left on the connection after the execution
+type sessionChange int
+
+const (
+	sessionUnchanged sessionChange = iota
+	// sessionDatabaseChanged is the database changed by USE, which can be reset
+	sessionDatabaseChanged
+	// sessionChanged is the state which can not be reset, or unknown after a failure
+	sessionChanged
+)
+
+// sessionVerbs are the statements leaving the state on the connection, in addition to the session and transaction statements
+var sessionVerbs = map[string]bool{
+	"LOCK": true, "UNLOCK": true, "PREPARE": true, "EXECUTE": true, "DEALLOCATE": true, "HANDLER": true, "CALL": true,
+}
+
+// statementSessionChange returns the state left on the connection by the query
+func statementSessionChange(query string) sessionChange {
+	class := ClassifyStatement(query)
+	switch {
+	case class.Verb == "USE":
+		return sessionDatabaseChanged
+	case class.Kind == StatementKindSession, class.Kind == StatementKindTransaction, sessionVerbs[class.Verb]:
+		return sessionChanged
+	}
+	for _, t := range tokenize(query) {
+		switch {
+		case t.kind == tokenWord && strings.HasPrefix(t.text, "@"):
+			// user variables, and system variables for simplicity
+			return sessionChanged
+		case t.keyword() == "TEMPORARY" && class.Verb == "CREATE", t.keyword() == "GET_LOCK":
+			return sessionChanged
+		}
+	}
+	return sessionUnchanged
+}
+
+var errAccessDenied = errors.New("access denied")
+
+func (c *DBCache) get(ctx context.Context, conf *Config) (*cachedDB, error) {
+	dsn, err := conf.GetDSN(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := dbCacheKey(dsn)
+	for {
+		cached := c.pop(key, dsn)
+		if cached == nil {
+			break
+		}
+		if err := c.ping(ctx, cached.db); err != nil {
+			log.Printf("cached connection is unhealthy, reconnect: %s", err)
+			cached.db.Close()
+			continue
+		}
+		return cached, nil
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ping(ctx, db); err != nil {
+		db.Close()
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1045 {
+			return nil, fmt.Errorf("%w: %w", errAccessDenied, err)
+		}
+		return nil, err
+	}
+	return &cachedDB{db: db, dsn: dsn}, nil
+}
+
+func (c *DBCache) ping(ctx context.Context, db *sql.DB) error {
+	if c.PingTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.PingTimeout)
+		defer cancel()
+	}
+	return db.PingContext(ctx)
+}
+
+// pop returns an idle DB of the key, and closes DBs expired or opened with the old password
+func (c *DBCache) pop(key string, dsn string) *cachedDB {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeExpired()
+	dbs := c.idle[key]
+	for len(dbs) > 0 {
+		cached := dbs[len(dbs)-1]
+		dbs = dbs[:len(dbs)-1]
+		if cached.dsn != dsn {
+			log.Println("password has changed, close the cached connection")
+			cached.db.Close()
+			continue
+		}
+		c.idle[key] = dbs
+		return cached
+	}
+	delete(c.idle, key)
+	return nil
+}
+
+func (c *DBCache) put(cached *cachedDB) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle == nil {
+		c.idle = make(map[string][]*cachedDB)
+	}
+	cached.lastUsed = flextime.Now()
+	key := dbCacheKey(cached.dsn)
+	c.idle[key] = append(c.idle[key], cached)
+}
+
+func (c *DBCache) closeExpired() {
+	maxIdleAge := c.MaxIdleAge
+	if maxIdleAge <= 0 {
+		maxIdleAge = DefaultDBCacheMaxIdleAge
+	}
+	for key, dbs := range c.idle {
+		alive := dbs[:0]
+		for _, cached := range dbs {
+			if flextime.Since(cached.lastUsed) >= maxIdleAge {
+				cached.db.Close()
+				continue
+			}
+			alive = append(alive, cached)
+		}
+		if len(alive) == 0 {
+			delete(c.idle, key)
+		} else {
+			c.idle[key] = alive
+		}
+	}
+}
+
+// Close closes all idle DBs
+func (c *DBCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for _, dbs := range c.idle {
+		for _, cached := range dbs {
+			if closeErr := cached.db.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}
+	c.idle = nil
+	return err
+}
+
+// dbCacheKey returns the DSN without the password, so that DBs opened with the old password are found after the rotation
+func dbCacheKey(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return dsn
+	}
+	cfg.Passwd = ""
+	return cfg.FormatDSN()
+}
@@ -0,0 +1,123 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBCache(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	cache := mysqlbatch.NewDBCache(time.Minute)
+	defer cache.Close()
+	ctx := context.Background()
+
+	e1, err := cache.New(ctx, conf)
+	require.NoError(t, err)
+	require.NoError(t, e1.Execute(strings.NewReader("SELECT 1;"), nil))
+	// in use DB is not shared
+	e2, err := cache.New(ctx, conf)
+	require.NoError(t, err)
+	require.NotSame(t, e1.DB(), e2.DB())
+	require.NoError(t, e2.Close())
+	require.NoError(t, e1.Close())
+	require.NoError(t, e1.Close(), "close twice")
+
+	e3, err := cache.New(ctx, conf)
+	require.NoError(t, err)
+	require.Same(t, e1.DB(), e3.DB())
+	require.NoError(t, e3.Execute(strings.NewReader("SELECT 1;"), nil))
+	require.NoError(t, e3.Close())
+
+	restore := flextime.Fix(time.Now().Add(2 * time.Minute))
+	defer restore()
+	e4, err := cache.New(ctx, conf)
+	require.NoError(t, err)
+	require.NotSame(t, e1.DB(), e4.DB(), "idle DB expired")
+	require.NotSame(t, e2.DB(), e4.DB(), "idle DB expired")
+	require.NoError(t, e4.Close())
+
+	// DB opened with the old password is closed after the rotation
+	rotated := *conf
+	rotated.Password = "rotated"
+	e5, err := cache.New(ctx, &rotated)
+	require.NoError(t, err)
+	require.NotSame(t, e4.DB(), e5.DB())
+	require.Error(t, e4.DB().Ping())
+	require.NoError(t, e5.Close())
+}
+
+func TestDBCache__Session(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	setup, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	require.NoError(t, setup.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+USE mysqlbatch;
+CREATE TABLE IF NOT EXISTS dbcache_session (id INT PRIMARY KEY);
+DELETE FROM dbcache_session;
+`), nil))
+	require.NoError(t, setup.Close())
+
+	conf.Database = "mysqlbatch"
+	cache := mysqlbatch.NewDBCache(time.Minute)
+	defer cache.Close()
+	ctx := context.Background()
+	selectValue := func(t *testing.T, e *mysqlbatch.Executer, query string) string {
+		t.Helper()
+		var value string
+		e.SetSelectHook(func(_ string, _ []string, rows [][]string) {
+			value = rows[0][0]
+		})
+		require.NoError(t, e.Execute(strings.NewReader(query), nil))
+		return value
+	}
+
+	cases := []struct {
+		name   string
+		batch  string
+		failed bool
+		reused bool
+	}{
+		{name: "select", batch: "SELECT 1;", reused: true},
+		{name: "use", batch: "USE information_schema;\nSELECT 1;", reused: true},
+		{name: "failed in transaction", batch: "START TRANSACTION;\nINSERT INTO dbcache_session VALUES (1);\nINSERT INTO dbcache_no_such_table VALUES (1);", failed: true},
+		{name: "failed", batch: "INSERT INTO dbcache_no_such_table VALUES (1);", failed: true},
+		{name: "transaction", batch: "START TRANSACTION;\nSELECT 1;"},
+		{name: "session variable", batch: "SET SESSION sql_mode = '';"},
+		{name: "user variable", batch: "SELECT 1 INTO @id;"},
+		{name: "lock", batch: "SELECT GET_LOCK('dbcache_session', 0);"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			e1, err := cache.New(ctx, conf)
+			require.NoError(t, err)
+			err = e1.Execute(strings.NewReader(c.batch), nil)
+			if c.failed {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, e1.Close())
+
+			e2, err := cache.New(ctx, conf)
+			require.NoError(t, err)
+			defer e2.Close()
+			if c.reused {
+				require.Same(t, e1.DB(), e2.DB())
+			} else {
+				require.NotSame(t, e1.DB(), e2.DB())
+				require.Error(t, e1.DB().Ping(), "not reused DB is closed")
+			}
+			require.Equal(t, "mysqlbatch", selectValue(t, e2, "SELECT DATABASE();"))
+			require.Equal(t, "0", selectValue(t, e2, "SELECT COUNT(*) FROM dbcache_session;"))
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package mysqlbatch
+
+// TemplateError is returned when the SQL template fails to be parsed or rendered, including the template header
+type TemplateError struct {
+	Err error
+}
+
+func (e *TemplateError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// StatementError is returned when a statement fails.
+// Index is the position of the statement in the batch, or in the statement group.
+type StatementError struct {
+	Index int
+	Query string
+	Err   error
+}
+
+func (e *StatementError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
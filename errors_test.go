@@ -0,0 +1,44 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuterExecute__ErrorTypes(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	err = e.Execute(strings.NewReader("SELECT 1;\nSELECT * FROM nosuch.t;\nSELECT 2;"), nil)
+	var stmtErr *mysqlbatch.StatementError
+	require.ErrorAs(t, err, &stmtErr)
+	require.Equal(t, 1, stmtErr.Index)
+	require.Equal(t, "SELECT * FROM nosuch.t", stmtErr.Query)
+	var mysqlErr *mysql.MySQLError
+	require.ErrorAs(t, err, &mysqlErr)
+
+	err = e.Execute(strings.NewReader("SELECT {{ value "), nil)
+	var templateErr *mysqlbatch.TemplateError
+	require.ErrorAs(t, err, &templateErr)
+	require.False(t, errors.As(err, &stmtErr))
+
+	err = e.ExecuteGroups([]mysqlbatch.QueryGroup{
+		{Name: "first", Reader: strings.NewReader("SELECT 1;")},
+		{Name: "second", Reader: strings.NewReader("SELECT 1;\nSELECT 2;\nSELECT * FROM nosuch.t;")},
+	}, nil)
+	var groupErr *mysqlbatch.GroupError
+	require.ErrorAs(t, err, &groupErr)
+	require.Equal(t, "second", groupErr.Name)
+	require.ErrorAs(t, err, &stmtErr)
+	require.Equal(t, 2, stmtErr.Index)
+}
@@ -2,11 +2,12 @@ package mysqlbatch
 
 import (
 	"bufio"
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"strings"
 	"sync"
@@ -22,17 +23,53 @@ func init() {
 	pongo2.SetAutoescape(false)
 }
 
+// templateMu serializes parsing templates, which writes to the default template set of pongo2
+var templateMu sync.Mutex
+
+// parseTemplate parses the template by the default template set, safe for concurrent executers
+func parseTemplate(bs []byte) (*pongo2.Template, error) {
+	templateMu.Lock()
+	defer templateMu.Unlock()
+	return pongo2.FromBytes(bs)
+}
+
 var DefaultSQLDumper io.Writer = io.Discard
 
-// Executer queries the DB. There is no parallelism
+// Executer queries the DB.
+// Statements are executed in order on one connection, except statement groups when parallelism is set.
 type Executer struct {
-	mu              sync.Mutex
-	db              *sql.DB
-	lastExecuteTime time.Time
-	selectHook      func(query string, columns []string, rows [][]string)
-	executeHook     func(query string, rowsAffected int64, lastInsertId int64)
-	isSelectFunc    func(query string) bool
-	timeCheckQuery  string
+	mu                  sync.Mutex
+	db                  *sql.DB
+	lastExecuteTime     time.Time
+	selectHook          func(query string, columns []string, rows [][]string)
+	resultSetHook       func(query string, index int, columns []string, rows [][]string)
+	resultSetReaderHook func(rs *ResultSetReader) error
+	warningHook         func(query string, warnings []*Warning)
+	warningsAsErrors    bool
+	explainHook         func(query string, plan *QueryPlan)
+	maxFullScanRows     int64
+	executeHook         func(result *ExecuteResult)
+	isSelectFunc        func(query string) bool
+	timeCheckQuery      string
+	fetcher             *SSMParameterFetcher
+	redactor            *Redactor
+	groupHook           func(result *GroupResult)
+	parallelism         int
+	collectGroupErr     bool
+	lagThrottle         *lagThrottle
+	lock                *namedLock
+	lastSkipped         bool
+	historyRecorder     HistoryRecorder
+	jobName             string
+	current             *execution
+	checkpointer        *checkpointer
+	safeUpdates         *safeUpdates
+	maxAffectedRows     int64
+	readOnly            bool
+	policy              *Policy
+	release             func() error
+	// sessionChange is the state left on the connection by the executions, for the DBCache
+	sessionChange sessionChange
 }
 
 // New return Executer with config
@@ -41,7 +78,14 @@ func New(ctx context.Context, conf *Config) (*Executer, error) {
 	if err != nil {
 		return nil, err
 	}
-	return Open(dsn)
+	e, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Fetcher != nil {
+		e.fetcher = conf.Fetcher
+	}
+	return e, nil
 }
 
 // Open with dsn
@@ -61,13 +105,18 @@ func NewWithDB(db *sql.DB) *Executer {
 	return &Executer{
 		db:             db,
 		timeCheckQuery: "SELECT NOW()",
+		fetcher:        &SSMParameterFetcher{},
+		redactor:       NewRedactor(),
 	}
 }
 
-// Close DB
+// Close DB, or return it to the DBCache
 func (e *Executer) Close() error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	if e.release != nil {
+		return e.release()
+	}
 	if e.db == nil {
 		return nil
 	}
@@ -83,10 +132,42 @@ func (e *Executer) Execute(queryReader io.Reader, vars map[string]string) error
 func (e *Executer) ExecuteContext(ctx context.Context, queryReader io.Reader, vars map[string]string) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	if err := e.executeContext(ctx, queryReader, vars); err != nil {
-		return err
+	return e.execute(ctx, vars, func() error {
+		return e.executeContext(ctx, queryReader, vars)
+	})
+}
+
+// execute runs f as a batch execution, updates the last execute time and records History.
+func (e *Executer) execute(ctx context.Context, vars map[string]string, f func() error) error {
+	e.lastSkipped = false
+	e.current = &execution{
+		sqlHash: sha256.New(),
+	}
+	var startedAt time.Time
+	if e.historyRecorder != nil {
+		startedAt = e.dbTime(ctx)
+		if r, ok := e.historyRecorder.(tableResolver); ok {
+			if err := r.resolveTable(ctx); err != nil {
+				log.Printf("resolve history table failed: %s", err)
+			}
+		}
+	}
+	err := f()
+	e.sessionChange = max(e.sessionChange, e.current.sessionChange)
+	if err != nil {
+		e.sessionChange = sessionChanged
+	}
+	if err == nil && !e.lastSkipped {
+		err = e.clearCheckpoint(ctx)
+	}
+	if err == nil {
+		err = e.updateLastExecuteTime(ctx)
 	}
-	return e.updateLastExecuteTime(ctx)
+	err = e.redactor.RedactError(err)
+	if e.historyRecorder != nil {
+		e.recordHistory(ctx, vars, startedAt, err)
+	}
+	return err
 }
 
 func (e *Executer) updateLastExecuteTime(ctx context.Context) error {
@@ -98,97 +179,288 @@ func (e *Executer) updateLastExecuteTime(ctx context.Context) error {
 }
 
 func (e *Executer) executeContext(ctx context.Context, queryReader io.Reader, vars map[string]string) error {
-	bs, err := io.ReadAll(queryReader)
+	rendered, err := e.render(ctx, queryReader, vars)
+	if err != nil {
+		return err
+	}
+	groups := splitStatementGroups(rendered)
+	if err := e.checkStatements(groups); err != nil {
+		return err
+	}
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get db connection")
+	}
+	defer conn.Close()
+	release, skipped, err := e.acquireLock(ctx, conn, vars)
 	if err != nil {
 		return err
 	}
-	tpl, err := pongo2.FromBytes(bs)
+	if skipped {
+		e.lastSkipped = true
+		return nil
+	}
+	defer release()
+	if err := e.prepareCheckpoint(ctx); err != nil {
+		return err
+	}
+	cleanup, err := e.prepareConn(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if _, err := e.executeStatements(ctx, conn, groups[0].statements, callHook); err != nil {
+		return err
+	}
+	return e.executeGroups(ctx, conn, groups[1:])
+}
+
+func (e *Executer) render(ctx context.Context, queryReader io.Reader, vars map[string]string) (string, error) {
+	bs, err := io.ReadAll(queryReader)
+	if err != nil {
+		return "", err
+	}
+	header, bs, err := ParseTemplateHeader(bs)
+	if err != nil {
+		return "", &TemplateError{Err: err}
+	}
+	if header != nil {
+		vars, err = header.ApplyVars(vars)
+		if err != nil {
+			return "", &TemplateError{Err: err}
+		}
+	}
+	e.redactor.registerVars(vars)
+	tpl, err := parseTemplate(bs)
 	if err != nil {
-		return errors.Wrap(err, "parse query template failed")
+		return "", &TemplateError{Err: errors.Wrap(err, "parse query template failed")}
 	}
-	var buf bytes.Buffer
+	var buf strings.Builder
 	if err := tpl.ExecuteWriter(e.newPongo2Ctx(ctx, vars), &buf); err != nil {
-		return errors.Wrap(err, "execute query template failed")
+		return "", &TemplateError{Err: errors.Wrap(err, "execute query template failed")}
+	}
+	if _, err := io.WriteString(DefaultSQLDumper, e.redactor.Redact(buf.String())); err != nil {
+		return "", errors.Wrap(err, "dump rendered sql failed")
 	}
-	reader := io.TeeReader(&buf, DefaultSQLDumper)
-	scanner := NewQueryScanner(reader)
-	for scanner.Scan() {
+	e.current.addRendered(buf.String())
+	return buf.String(), nil
+}
+
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// callHook calls hook immediately
+func callHook(hook func()) {
+	hook()
+}
+
+// executeStatements executes statements in order, and returns the number of executed statements.
+// emit is called with the hook invocation of each statement.
+// Statements completed before the checkpoint are skipped, except the ones changing the session state.
+func (e *Executer) executeStatements(ctx context.Context, q queryer, stmts []*statement, emit func(func())) (int, error) {
+	for i, stmt := range stmts {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return i, ctx.Err()
 		default:
 		}
-		query := scanner.Query()
-		if query == "" {
+		index, completed := e.current.nextStatement()
+		if completed && !stmt.isSessionState() {
 			continue
 		}
-		if e.selectHook != nil {
-			upperedQuery := strings.ToUpper(query)
-			var isSelect bool
-			if e.isSelectFunc == nil {
-				if strings.HasPrefix(upperedQuery, "SELECT") || strings.HasPrefix(upperedQuery, "SHOW") || strings.HasPrefix(upperedQuery, `\`) {
-					isSelect = true
-				}
-			} else {
-				isSelect = e.isSelectFunc(upperedQuery)
-			}
-			if isSelect {
-				if err := e.queryContext(ctx, query); err != nil {
-					return fmt.Errorf("query `%s` failed: %w", query, err)
-				}
-				continue
-			}
+		if err := e.waitForReplicaLag(ctx); err != nil {
+			return i, err
 		}
-		result, err := e.db.ExecContext(ctx, query)
-		if err != nil {
-			return fmt.Errorf("execute query `%s` failed: %w", query, err)
+		e.current.addSessionChange(statementSessionChange(stmt.query))
+		if err := e.executeStatement(ctx, q, stmt, emit); err != nil {
+			return i, &StatementError{Index: i, Query: e.redactor.Redact(stmt.query), Err: err}
 		}
-		if e.executeHook != nil {
-			lastInsertId, err := result.LastInsertId()
-			if err != nil {
-				return err
+		// statements in a transaction are rolled back on failure, so the checkpoint advances at COMMIT
+		if !e.current.inTransaction(q) {
+			if err := e.saveCheckpoint(ctx, index); err != nil {
+				return i, err
 			}
-			rowsAffected, err := result.RowsAffected()
-			if err != nil {
-				return err
+		}
+	}
+	return len(stmts), nil
+}
+
+func (e *Executer) executeStatement(ctx context.Context, q queryer, stmt *statement, emit func(func())) error {
+	query := stmt.query
+	redactedQuery := e.redactor.Redact(query)
+	if err := e.explainStatement(ctx, q, query, redactedQuery, emit); err != nil {
+		return err
+	}
+	if e.selectHook != nil || e.resultSetHook != nil || e.resultSetReaderHook != nil {
+		if e.isSelect(query) || ClassifyStatement(query).Kind == StatementKindCall {
+			if err := e.queryContext(ctx, q, stmt, redactedQuery, emit); err != nil {
+				return fmt.Errorf("query `%s` failed: %w", redactedQuery, err)
 			}
-			e.executeHook(query, rowsAffected, lastInsertId)
+			return nil
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return errors.Wrap(scanner.Err(), "query scanner err")
+	limit, err := e.affectedRowsLimit(stmt)
+	if err != nil {
+		return fmt.Errorf("query `%s`: %w", redactedQuery, err)
+	}
+	if chunk, ok := stmt.annotation("chunk"); ok {
+		return e.executeChunked(ctx, q, query, redactedQuery, chunk, limit, emit)
+	}
+	start := time.Now()
+	result, err := q.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("execute query `%s` failed: %w", redactedQuery, err)
+	}
+	duration := time.Since(start)
+	lastInsertId, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	warnings, err := e.fetchWarnings(ctx, q, query)
+	if err != nil {
+		return err
+	}
+	if err := e.checkAffectedRows(ctx, q, redactedQuery, rowsAffected, limit); err != nil {
+		return err
 	}
-	return nil
+	e.current.trackTransaction(q, tokenize(query))
+	e.current.addStatement(redactedQuery, rowsAffected)
+	e.reportExecuteResult(emit, &ExecuteResult{
+		Query:        redactedQuery,
+		Kind:         ClassifyStatement(query).Kind,
+		RowsAffected: rowsAffected,
+		LastInsertID: lastInsertId,
+		Duration:     duration,
+	})
+	return e.reportWarnings(redactedQuery, warnings, emit)
 }
 
-func (e *Executer) queryContext(ctx context.Context, query string) error {
-	iter, err := e.db.QueryContext(ctx, query)
+// ExecuteResult is the result of a statement not returning rows
+type ExecuteResult struct {
+	Query        string
+	Kind         StatementKind
+	RowsAffected int64
+	LastInsertID int64
+	// Duration is the time taken by the statement, the total of all chunks excluding sleeps for a chunked statement
+	Duration time.Duration
+}
+
+func (e *Executer) reportExecuteResult(emit func(func()), result *ExecuteResult) {
+	if e.executeHook != nil {
+		emit(func() {
+			e.executeHook(result)
+		})
+	}
+}
+
+// prepareConn sets up the session of conn for the execution, and returns the function cleaning up it
+func (e *Executer) prepareConn(ctx context.Context, conn *sql.Conn) (func(), error) {
+	restore, err := e.setSessionSafeUpdates(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	end, err := e.startReadOnlyTransaction(ctx, conn)
+	if err != nil {
+		restore()
+		return nil, err
+	}
+	return func() {
+		end()
+		e.rollbackUncommitted(conn)
+		restore()
+	}, nil
+}
+
+// rollbackUncommitted ends the transaction left open by the batch, e.g. failed before COMMIT,
+// so that the connection returned to the pool does not keep it for the history and the next execution.
+// Only the transaction started by the statements of the batch is rolled back.
+func (e *Executer) rollbackUncommitted(conn *sql.Conn) {
+	if !e.current.inTransaction(conn) {
+		return
+	}
+	log.Println("rollback the transaction left open by the batch")
+	// end even if ctx is canceled, because the connection is returned to the pool
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := conn.ExecContext(ctx, "ROLLBACK"); err != nil {
+		log.Printf("rollback uncommitted transaction failed: %s", err)
+	}
+}
+
+// isSelect returns true if the query returns rows
+func (e *Executer) isSelect(query string) bool {
+	if e.isSelectFunc != nil {
+		return e.isSelectFunc(strings.ToUpper(query))
+	}
+	return IsSelectStatement(query)
+}
+
+// queryContext executes the query, and emits all result sets of the query with the index.
+// OUT parameters of CALL are emitted as the last result set if requested by the annotation.
+func (e *Executer) queryContext(ctx context.Context, q queryer, stmt *statement, redactedQuery string, emit func(func())) error {
+	iter, err := q.QueryContext(ctx, stmt.query)
 	if err != nil {
 		return err
 	}
 	defer iter.Close()
-	columns, err := iter.Columns()
-	if err != nil {
+	index := 0
+	for {
+		columns, err := iter.Columns()
+		if err != nil {
+			return err
+		}
+		// the status result of CALL has no columns
+		if len(columns) > 0 {
+			if err := e.readResultSet(emit, redactedQuery, index, columns, iter); err != nil {
+				return err
+			}
+			index++
+		}
+		if !iter.NextResultSet() {
+			break
+		}
+	}
+	if err := iter.Err(); err != nil {
 		return err
 	}
-	rows := make([][]string, 0)
-	iRow := make([]interface{}, len(columns))
-	sRow := make([]sql.NullString, len(columns))
-	for i := range sRow {
-		iRow[i] = &sRow[i]
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	// fetch before selecting OUT parameters, which clears warnings
+	warnings, err := e.fetchWarnings(ctx, q, stmt.query)
+	if err != nil {
+		return err
 	}
-	for iter.Next() {
-		if err := iter.Scan(iRow...); err != nil {
+	if a, ok := stmt.annotation("out-params"); ok {
+		if err := e.queryOutParams(ctx, q, stmt.query, a, func(columns []string, iter *sql.Rows) error {
+			return e.readResultSet(emit, redactedQuery, index, columns, iter)
+		}); err != nil {
 			return err
 		}
-		row := make([]string, len(columns))
-		for i := range row {
-			row[i] = sRow[i].String
-		}
-		rows = append(rows, row)
 	}
-	e.selectHook(query, columns, rows)
-	return nil
+	e.current.addStatement(redactedQuery, 0)
+	return e.reportWarnings(redactedQuery, warnings, emit)
+}
+
+func (e *Executer) emitResultSet(emit func(func()), query string, index int, columns []string, rows [][]string) {
+	emit(func() {
+		if e.resultSetHook != nil {
+			e.resultSetHook(query, index, columns, rows)
+			return
+		}
+		e.selectHook(query, columns, rows)
+	})
+}
+
+// DB returns *sql.DB of the executer
+func (e *Executer) DB() *sql.DB {
+	return e.db
 }
 
 // LastExecuteTime returns last execute time on DB
@@ -198,6 +470,17 @@ func (e *Executer) LastExecuteTime() time.Time {
 
 // SetExecuteHook set non select query hook
 func (e *Executer) SetExecuteHook(hook func(query string, rowsAffected, lastInsertId int64)) {
+	if hook == nil {
+		e.executeHook = nil
+		return
+	}
+	e.executeHook = func(result *ExecuteResult) {
+		hook(result.Query, result.RowsAffected, result.LastInsertID)
+	}
+}
+
+// SetExecuteResultHook set non select query hook receiving the kind and the duration of the statement
+func (e *Executer) SetExecuteResultHook(hook func(result *ExecuteResult)) {
 	e.executeHook = hook
 }
 
@@ -206,11 +489,32 @@ func (e *Executer) SetSelectHook(hook func(query string, columns []string, rows
 	e.selectHook = hook
 }
 
-// SetIsSelectFunc :Set the function to decide whether to execute in QueryContext
+// SetResultSetHook set the hook called with each result set of select queries and CALL statements, and its index in the query.
+// If set, it is called instead of the select hook.
+func (e *Executer) SetResultSetHook(hook func(query string, index int, columns []string, rows [][]string)) {
+	e.resultSetHook = hook
+}
+
+// SetIsSelectFunc :Set the function to decide whether to execute in QueryContext, default IsSelectStatement
 func (e *Executer) SetIsSelectFunc(f func(query string) bool) {
 	e.isSelectFunc = f
 }
 
+// SetSSMParameterFetcher set fetcher for ssm() and secret() template functions
+func (e *Executer) SetSSMParameterFetcher(fetcher *SSMParameterFetcher) {
+	e.fetcher = fetcher
+}
+
+// Redactor returns Redactor that masks sensitive values in dumped SQL, hooks and errors
+func (e *Executer) Redactor() *Redactor {
+	return e.redactor
+}
+
+// SetRedactor set Redactor
+func (e *Executer) SetRedactor(r *Redactor) {
+	e.redactor = r
+}
+
 // SetTimeCheckQuery set time check query for non mysql db
 func (e *Executer) SetTimeCheckQuery(query string) {
 	e.timeCheckQuery = query
@@ -228,7 +532,7 @@ func (e *Executer) SetTableSelectHook(hook func(query, table string)) {
 	}
 }
 
-func (e *Executer) newPongo2Ctx(_ context.Context, vars map[string]string) pongo2.Context {
+func (e *Executer) newPongo2Ctx(ctx context.Context, vars map[string]string) pongo2.Context {
 	pongo2Ctx := pongo2.Context{
 		"var": func(key string, defaultValue string) string {
 			if v, ok := vars[key]; ok {
@@ -254,6 +558,28 @@ func (e *Executer) newPongo2Ctx(_ context.Context, vars map[string]string) pongo
 			}
 			return "", errors.Errorf("environment variable %s is not defined", key)
 		},
+		"ssm": func(name string, jsonKey ...string) (string, error) {
+			if len(jsonKey) > 1 {
+				return "", fmt.Errorf("ssm requires at most 2 arguments, got %d", len(jsonKey)+1)
+			}
+			v, err := e.fetcher.Fetch(ctx, name, strings.Join(jsonKey, ""))
+			if err != nil {
+				return "", errors.Wrapf(err, "ssm parameter %s", name)
+			}
+			e.redactor.AddValue(v)
+			return v, nil
+		},
+		"secret": func(secretID string, jsonKey ...string) (string, error) {
+			if len(jsonKey) > 1 {
+				return "", fmt.Errorf("secret requires at most 2 arguments, got %d", len(jsonKey)+1)
+			}
+			v, err := e.fetcher.FetchSecret(ctx, secretID, strings.Join(jsonKey, ""))
+			if err != nil {
+				return "", errors.Wrapf(err, "secret %s", secretID)
+			}
+			e.redactor.AddValue(v)
+			return v, nil
+		},
 		"range": func(args ...int) ([]int, error) {
 			if len(args) == 0 {
 				return nil, errors.New("range requires at least 1 argument, got 0")
@@ -322,9 +648,5 @@ func NewQueryScanner(queryReader io.Reader) *QueryScanner {
 
 // Query return
 func (s *QueryScanner) Query() string {
-	return strings.Trim(strings.NewReplacer(
-		"\r\n", " ",
-		"\r", " ",
-		"\n", " ",
-	).Replace(s.Text()), " \t")
+	return normalizeQuery(s.Text())
 }
@@ -5,6 +5,7 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -12,6 +13,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go/middleware"
 	"github.com/mashiike/mysqlbatch"
 	"github.com/sergi/go-diff/diffmatchpatch"
 	"github.com/stretchr/testify/require"
@@ -123,6 +129,45 @@ func TestExecuterExecute(t *testing.T) {
 	require.EqualValues(t, 1, count)
 }
 
+func TestExecuterExecute__WithExecuteResultHook(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	var results []*mysqlbatch.ExecuteResult
+	e.SetExecuteResultHook(func(result *mysqlbatch.ExecuteResult) {
+		require.Greater(t, result.Duration, time.Duration(0))
+		result.Duration = 0
+		results = append(results, result)
+	})
+	err = e.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+USE mysqlbatch;
+DROP TABLE IF EXISTS execute_results;
+CREATE TABLE execute_results (id BIGINT AUTO_INCREMENT PRIMARY KEY, name VARCHAR(32));
+INSERT INTO execute_results(name) VALUES ('a'), ('b'), ('c');
+UPDATE execute_results SET name = 'x' WHERE id >= 2;
+`), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 6)
+	require.Equal(t, &mysqlbatch.ExecuteResult{
+		Query:        "INSERT INTO execute_results(name) VALUES ('a'), ('b'), ('c')",
+		Kind:         mysqlbatch.StatementKindDML,
+		RowsAffected: 3,
+		LastInsertID: 1,
+	}, results[4])
+	require.Equal(t, &mysqlbatch.ExecuteResult{
+		Query:        "UPDATE execute_results SET name = 'x' WHERE id >= 2",
+		Kind:         mysqlbatch.StatementKindDML,
+		RowsAffected: 2,
+	}, results[5])
+	require.Equal(t, mysqlbatch.StatementKindDDL, results[3].Kind)
+	require.Equal(t, mysqlbatch.StatementKindSession, results[1].Kind)
+}
+
 func TestExecuterExecute__WithVars(t *testing.T) {
 	os.Setenv("ENV", "test")
 	mysqlbatch.DefaultSQLDumper = os.Stderr
@@ -153,3 +198,55 @@ func TestExecuterExecute__WithVars(t *testing.T) {
 	require.InDelta(t, time.Since(e.LastExecuteTime()), 0, float64(5*time.Minute))
 	require.EqualValues(t, 1, count)
 }
+
+func TestExecuterExecute__WithSSM(t *testing.T) {
+	var dumped bytes.Buffer
+	defer func(w io.Writer) {
+		mysqlbatch.DefaultSQLDumper = w
+	}(mysqlbatch.DefaultSQLDumper)
+	mysqlbatch.DefaultSQLDumper = &dumped
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	conf.Fetcher = &mysqlbatch.SSMParameterFetcher{
+		LoadAWSDefaultConfigOptions: []func(*config.LoadOptions) error{
+			config.WithRegion("ap-northeast-1"),
+			config.WithAPIOptions([]func(stack *middleware.Stack) error{
+				func(stack *middleware.Stack) error {
+					return stack.Initialize.Add(
+						middleware.InitializeMiddlewareFunc("test",
+							func(_ context.Context, in middleware.InitializeInput, _ middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+								value := `{"tenant":"tenant_db","cutoff":"2023-01-01"}`
+								if strings.HasPrefix(*in.Parameters.(*ssm.GetParameterInput).Name, "/aws/reference/secretsmanager/") {
+									value = "api_token"
+								}
+								return middleware.InitializeOutput{
+									Result: &ssm.GetParameterOutput{
+										Parameter: &types.Parameter{
+											Value: aws.String(value),
+										},
+									},
+								}, middleware.Metadata{}, nil
+							},
+						),
+						middleware.Before,
+					)
+				},
+			}),
+		},
+	}
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	var queries []string
+	e.SetSelectHook(func(query string, columns []string, rows [][]string) {
+		queries = append(queries, query)
+		require.Equal(t, [][]string{{"tenant_db", "2023-01-01", "api_token"}}, rows)
+	})
+	err = e.Execute(strings.NewReader(`SELECT '{{ ssm("/test/CONFIG", "tenant") }}' AS tenant, '{{ ssm("/test/CONFIG", "cutoff") }}' AS cutoff, '{{ secret("test/TOKEN") }}' AS token;`), nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{`SELECT '********' AS tenant, '********' AS cutoff, '********' AS token`}, queries)
+	require.NotContains(t, dumped.String(), "tenant_db")
+	require.NotContains(t, dumped.String(), "api_token")
+}
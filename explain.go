@@ -0,0 +1,219 @@
+package mysqlbatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// QueryPlan is the summary of EXPLAIN FORMAT=JSON
+type QueryPlan struct {
+	// Cost is query_cost of the top level query block
+	Cost   float64      `json:"cost"`
+	Tables []*TablePlan `json:"tables"`
+}
+
+// TablePlan is the access plan of a table in the query
+type TablePlan struct {
+	Table      string `json:"table"`
+	AccessType string `json:"access_type"`
+	Key        string `json:"key,omitempty"`
+	// RowsExamined is the estimated rows examined per scan
+	RowsExamined int64 `json:"rows_examined"`
+}
+
+// FullScan returns true if the table is read by full table scan
+func (t *TablePlan) FullScan() bool {
+	return strings.EqualFold(t.AccessType, "ALL")
+}
+
+func (t *TablePlan) String() string {
+	key := t.Key
+	if key == "" {
+		key = "-"
+	}
+	s := fmt.Sprintf("%s: access %s, key %s, rows examined %d", t.Table, t.AccessType, key, t.RowsExamined)
+	if t.FullScan() {
+		s += " (full scan)"
+	}
+	return s
+}
+
+// FullScans returns the tables read by full table scan
+func (p *QueryPlan) FullScans() []*TablePlan {
+	var tables []*TablePlan
+	for _, t := range p.Tables {
+		if t.FullScan() {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// RowsExamined returns the sum of the estimated rows examined of all tables
+func (p *QueryPlan) RowsExamined() int64 {
+	var n int64
+	for _, t := range p.Tables {
+		n += t.RowsExamined
+	}
+	return n
+}
+
+func (p *QueryPlan) String() string {
+	lines := []string{
+		fmt.Sprintf("cost %.2f, %d full scans, rows examined %d", p.Cost, len(p.FullScans()), p.RowsExamined()),
+	}
+	for _, t := range p.Tables {
+		lines = append(lines, "  "+t.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseExplainJSON parses the output of EXPLAIN FORMAT=JSON.
+// Tables in nested loops, subqueries and derived tables are all listed, tables in a nested loop are in the join order.
+func ParseExplainJSON(data []byte) (*QueryPlan, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, errors.Wrap(err, "parse explain json")
+	}
+	block, ok := root["query_block"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("parse explain json: query_block not found")
+	}
+	plan := &QueryPlan{}
+	if costInfo, ok := block["cost_info"].(map[string]interface{}); ok {
+		plan.Cost = jsonFloat(costInfo["query_cost"])
+	}
+	collectTablePlans(block, plan)
+	return plan, nil
+}
+
+// collectTablePlans walks the JSON value and appends `table` objects to the plan
+func collectTablePlans(v interface{}, plan *QueryPlan) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if t, ok := v["table"].(map[string]interface{}); ok {
+			if name, ok := t["table_name"].(string); ok {
+				tp := &TablePlan{
+					Table:        name,
+					RowsExamined: int64(jsonFloat(t["rows_examined_per_scan"])),
+				}
+				tp.AccessType, _ = t["access_type"].(string)
+				tp.Key, _ = t["key"].(string)
+				plan.Tables = append(plan.Tables, tp)
+			}
+		}
+		// sort keys for the stable order, JSON objects are unordered in Go
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collectTablePlans(v[key], plan)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectTablePlans(item, plan)
+		}
+	}
+}
+
+// jsonFloat returns the number in JSON, which is a string in some fields like query_cost
+func jsonFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// FullScanError is returned when a statement would full-scan a table estimated more rows than the limit
+type FullScanError struct {
+	Query        string
+	Table        string
+	RowsExamined int64
+	Limit        int64
+}
+
+func (e *FullScanError) Error() string {
+	return fmt.Sprintf("query `%s` would full-scan table %s examining %d rows, exceeds the limit %d", e.Query, e.Table, e.RowsExamined, e.Limit)
+}
+
+// SetExplainHook set the hook called with the query plan of each SELECT, INSERT, UPDATE, DELETE and REPLACE statement before execution.
+func (e *Executer) SetExplainHook(hook func(query string, plan *QueryPlan)) {
+	e.explainHook = hook
+}
+
+// SetMaxFullScanRows set the limit of the estimated rows of a full table scan.
+// Each SELECT, INSERT, UPDATE, DELETE and REPLACE statement is explained before execution, and the execution is aborted if the plan exceeds the limit.
+// n <= 0 means unlimited.
+func (e *Executer) SetMaxFullScanRows(n int64) {
+	e.maxFullScanRows = n
+}
+
+// explain returns the plan of the query on q
+func explain(ctx context.Context, q queryer, query string) (*QueryPlan, error) {
+	iter, err := q.QueryContext(ctx, "EXPLAIN FORMAT=JSON "+query)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	if !iter.Next() {
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("explain returns no rows")
+	}
+	var data []byte
+	if err := iter.Scan(&data); err != nil {
+		return nil, err
+	}
+	return ParseExplainJSON(data)
+}
+
+// explainableVerbs are the statements supported by EXPLAIN
+var explainableVerbs = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "REPLACE": true,
+}
+
+// explainStatement explains the query on q before execution, emits the plan to the hook, and returns FullScanError if the plan exceeds the limit
+func (e *Executer) explainStatement(ctx context.Context, q queryer, query string, redactedQuery string, emit func(func())) error {
+	if e.explainHook == nil && e.maxFullScanRows <= 0 {
+		return nil
+	}
+	if !explainableVerbs[ClassifyStatement(query).Verb] {
+		return nil
+	}
+	plan, err := explain(ctx, q, query)
+	if err != nil {
+		return fmt.Errorf("explain query `%s` failed: %w", redactedQuery, err)
+	}
+	if e.explainHook != nil {
+		emit(func() {
+			e.explainHook(redactedQuery, plan)
+		})
+	}
+	if e.maxFullScanRows <= 0 {
+		return nil
+	}
+	for _, t := range plan.FullScans() {
+		if t.RowsExamined > e.maxFullScanRows {
+			return &FullScanError{
+				Query:        redactedQuery,
+				Table:        t.Table,
+				RowsExamined: t.RowsExamined,
+				Limit:        e.maxFullScanRows,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package mysqlbatch_test
+
+import (
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExplainJSON(t *testing.T) {
+	data := []byte(`{
+  "query_block": {
+    "select_id": 1,
+    "cost_info": {
+      "query_cost": "1210.50"
+    },
+    "nested_loop": [
+      {
+        "table": {
+          "table_name": "u",
+          "access_type": "ALL",
+          "possible_keys": ["PRIMARY"],
+          "rows_examined_per_scan": 1000,
+          "rows_produced_per_join": 1000,
+          "filtered": "100.00",
+          "cost_info": {
+            "read_cost": "2.50",
+            "eval_cost": "100.00",
+            "prefix_cost": "102.50",
+            "data_read_per_join": "1M"
+          },
+          "used_columns": ["id", "name"]
+        }
+      },
+      {
+        "table": {
+          "table_name": "o",
+          "access_type": "ref",
+          "possible_keys": ["idx_user_id"],
+          "key": "idx_user_id",
+          "used_key_parts": ["user_id"],
+          "key_length": "8",
+          "ref": ["app.u.id"],
+          "rows_examined_per_scan": 10,
+          "rows_produced_per_join": 10000,
+          "filtered": "100.00",
+          "attached_condition": "(o.status = 'paid')"
+        }
+      }
+    ]
+  }
+}`)
+	plan, err := mysqlbatch.ParseExplainJSON(data)
+	require.NoError(t, err)
+	require.Equal(t, &mysqlbatch.QueryPlan{
+		Cost: 1210.5,
+		Tables: []*mysqlbatch.TablePlan{
+			{Table: "u", AccessType: "ALL", RowsExamined: 1000},
+			{Table: "o", AccessType: "ref", Key: "idx_user_id", RowsExamined: 10},
+		},
+	}, plan)
+	require.Len(t, plan.FullScans(), 1)
+	require.EqualValues(t, 1010, plan.RowsExamined())
+	require.Equal(t, `cost 1210.50, 1 full scans, rows examined 1010
+  u: access ALL, key -, rows examined 1000 (full scan)
+  o: access ref, key idx_user_id, rows examined 10`, plan.String())
+
+	plan, err = mysqlbatch.ParseExplainJSON([]byte(`{
+  "query_block": {
+    "select_id": 1,
+    "table": {
+      "delete": true,
+      "table_name": "events",
+      "access_type": "range",
+      "key": "idx_created_at",
+      "rows_examined_per_scan": 25,
+      "attached_condition": "(events.created_at < '2023-01-01')"
+    }
+  }
+}`))
+	require.NoError(t, err)
+	require.Equal(t, []*mysqlbatch.TablePlan{
+		{Table: "events", AccessType: "range", Key: "idx_created_at", RowsExamined: 25},
+	}, plan.Tables)
+	require.Empty(t, plan.FullScans())
+
+	_, err = mysqlbatch.ParseExplainJSON([]byte(`{"plan": {}}`))
+	require.Error(t, err)
+}
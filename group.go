@@ -0,0 +1,294 @@
+package mysqlbatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// groupDirective starts a new statement group, e.g. `-- mysqlbatch:group shard1`
+var groupDirective = regexp.MustCompile(`^\s*--\s*mysqlbatch:group(?:\s+(\S+))?\s*$`)
+
+// QueryGroup is a SQL template executed as an independent statement group
+type QueryGroup struct {
+	Name   string
+	Reader io.Reader
+}
+
+// GroupResult is a result of the statement group
+type GroupResult struct {
+	Index      int
+	Name       string
+	Statements int
+	Duration   time.Duration
+	Err        error
+}
+
+// GroupError is an error of the statement group
+type GroupError struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e *GroupError) Error() string {
+	return fmt.Sprintf("group %s failed: %s", e.Name, e.Err)
+}
+
+func (e *GroupError) Unwrap() error {
+	return e.Err
+}
+
+// GroupErrors is the errors of all failed statement groups
+type GroupErrors []*GroupError
+
+func (errs GroupErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (errs GroupErrors) Unwrap() []error {
+	unwrapped := make([]error, 0, len(errs))
+	for _, err := range errs {
+		unwrapped = append(unwrapped, err)
+	}
+	return unwrapped
+}
+
+type statementGroup struct {
+	index      int
+	name       string
+	statements []*statement
+}
+
+// splitStatementGroups splits rendered SQL by group directives.
+// The first element is the statements before the first directive, which are executed before all groups.
+func splitStatementGroups(rendered string) []*statementGroup {
+	groups := []*statementGroup{{index: -1}}
+	var buf strings.Builder
+	flush := func() {
+		groups[len(groups)-1].statements = scanStatements(buf.String())
+		buf.Reset()
+	}
+	for _, line := range strings.SplitAfter(rendered, "\n") {
+		m := groupDirective.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+		if m == nil {
+			buf.WriteString(line)
+			continue
+		}
+		flush()
+		name := m[1]
+		if name == "" {
+			name = fmt.Sprintf("group%d", len(groups))
+		}
+		groups = append(groups, &statementGroup{index: len(groups) - 1, name: name})
+	}
+	flush()
+	return groups
+}
+
+func scanStatements(rendered string) []*statement {
+	var stmts []*statement
+	scanner := NewQueryScanner(strings.NewReader(rendered))
+	for scanner.Scan() {
+		if stmt := parseStatement(scanner.Text()); stmt.query != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// ExecuteGroups executes each SQL template as an independent statement group
+func (e *Executer) ExecuteGroups(groups []QueryGroup, vars map[string]string) error {
+	return e.ExecuteGroupsContext(context.Background(), groups, vars)
+}
+
+// ExecuteGroupsContext executes each SQL template as an independent statement group with context.Context
+func (e *Executer) ExecuteGroupsContext(ctx context.Context, groups []QueryGroup, vars map[string]string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.execute(ctx, vars, func() error {
+		return e.executeGroupsContext(ctx, groups, vars)
+	})
+}
+
+func (e *Executer) executeGroupsContext(ctx context.Context, groups []QueryGroup, vars map[string]string) error {
+	statementGroups := make([]*statementGroup, 0, len(groups))
+	for i, g := range groups {
+		rendered, err := e.render(ctx, g.Reader, vars)
+		if err != nil {
+			return errors.Wrapf(err, "group %s", g.Name)
+		}
+		statementGroups = append(statementGroups, &statementGroup{
+			index:      i,
+			name:       g.Name,
+			statements: scanStatements(rendered),
+		})
+	}
+	if err := e.checkStatements(statementGroups); err != nil {
+		return err
+	}
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get db connection")
+	}
+	defer conn.Close()
+	release, skipped, err := e.acquireLock(ctx, conn, vars)
+	if err != nil {
+		return err
+	}
+	if skipped {
+		e.lastSkipped = true
+		return nil
+	}
+	defer release()
+	if err := e.prepareCheckpoint(ctx); err != nil {
+		return err
+	}
+	cleanup, err := e.prepareConn(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return e.executeGroups(ctx, conn, statementGroups)
+}
+
+// executeGroups executes statement groups.
+// If parallelism is set, each group is executed on its own connection, otherwise in order on conn.
+// Hooks are called in the order of the groups.
+func (e *Executer) executeGroups(ctx context.Context, conn queryer, groups []*statementGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	if e.parallelism <= 1 {
+		var errs GroupErrors
+		for _, g := range groups {
+			result := e.executeGroup(ctx, conn, g, callHook)
+			e.reportGroup(result)
+			if result.Err == nil {
+				continue
+			}
+			groupErr := &GroupError{Index: g.index, Name: g.name, Err: result.Err}
+			if !e.collectGroupErr {
+				return groupErr
+			}
+			errs = append(errs, groupErr)
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		return nil
+	}
+
+	eg, egctx := errgroup.WithContext(ctx)
+	if e.collectGroupErr {
+		eg, egctx = &errgroup.Group{}, ctx
+	}
+	eg.SetLimit(e.parallelism)
+	var (
+		mu      sync.Mutex
+		next    int
+		results = make([]*GroupResult, len(groups))
+		events  = make([][]func(), len(groups))
+	)
+	for i, g := range groups {
+		i, g := i, g
+		eg.Go(func() error {
+			var result *GroupResult
+			groupConn, err := e.db.Conn(egctx)
+			if err != nil {
+				result = &GroupResult{Index: g.index, Name: g.name, Err: errors.Wrap(err, "get db connection")}
+			} else if cleanup, err := e.prepareConn(egctx, groupConn); err != nil {
+				result = &GroupResult{Index: g.index, Name: g.name, Err: err}
+				groupConn.Close()
+			} else {
+				result = e.executeGroup(egctx, groupConn, g, func(hook func()) {
+					events[i] = append(events[i], hook)
+				})
+				cleanup()
+				groupConn.Close()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results[i] = result
+			for next < len(groups) && results[next] != nil {
+				for _, hook := range events[next] {
+					hook()
+				}
+				e.reportGroup(results[next])
+				next++
+			}
+			if result.Err != nil {
+				return &GroupError{Index: g.index, Name: g.name, Err: result.Err}
+			}
+			return nil
+		})
+	}
+	err := eg.Wait()
+	if !e.collectGroupErr {
+		return err
+	}
+	var errs GroupErrors
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, &GroupError{Index: result.Index, Name: result.Name, Err: result.Err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (e *Executer) executeGroup(ctx context.Context, q queryer, g *statementGroup, emit func(func())) *GroupResult {
+	start := time.Now()
+	n, err := e.executeStatements(ctx, q, g.statements, emit)
+	return &GroupResult{
+		Index:      g.index,
+		Name:       g.name,
+		Statements: n,
+		Duration:   time.Since(start),
+		Err:        err,
+	}
+}
+
+func (e *Executer) reportGroup(result *GroupResult) {
+	if e.groupHook != nil {
+		e.groupHook(result)
+	}
+}
+
+// SetParallelism set the number of statement groups executed concurrently.
+// Each concurrent group uses its own connection, so session settings must be written in each group.
+func (e *Executer) SetParallelism(n int) {
+	e.parallelism = n
+	if n > 1 {
+		// one more connection for the statements before the first group
+		e.db.SetMaxOpenConns(n + 1)
+		e.db.SetMaxIdleConns(n + 1)
+		return
+	}
+	e.db.SetMaxOpenConns(1)
+	e.db.SetMaxIdleConns(1)
+}
+
+// SetCollectGroupErrors set whether to execute all statement groups even if a group fails.
+// If false (default), the first failure cancels other groups.
+func (e *Executer) SetCollectGroupErrors(collect bool) {
+	e.collectGroupErr = collect
+}
+
+// SetGroupHook set hook called after each statement group is executed
+func (e *Executer) SetGroupHook(hook func(result *GroupResult)) {
+	e.groupHook = hook
+}
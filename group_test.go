@@ -0,0 +1,75 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+const testGroupSQL = `
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+-- mysqlbatch:group first
+SELECT 1 AS n, SLEEP(0.2);
+SELECT 2 AS n;
+-- mysqlbatch:group second
+SELECT 3 AS n;
+-- mysqlbatch:group
+SELECT 4 AS n, SLEEP(0.1);
+`
+
+func TestExecuterExecute__WithGroups(t *testing.T) {
+	for _, parallelism := range []int{1, 3} {
+		conf := mysqlbatch.NewDefaultConfig()
+		conf.Password = "mysqlbatch"
+		conf.Location = "Asia/Tokyo"
+		e, err := mysqlbatch.New(context.Background(), conf)
+		require.NoError(t, err)
+		defer e.Close()
+		e.SetParallelism(parallelism)
+
+		var values []string
+		e.SetSelectHook(func(query string, columns []string, rows [][]string) {
+			values = append(values, rows[0][0])
+		})
+		var groups []string
+		e.SetGroupHook(func(result *mysqlbatch.GroupResult) {
+			require.NoError(t, result.Err)
+			groups = append(groups, result.Name)
+		})
+		err = e.Execute(strings.NewReader(testGroupSQL), nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"1", "2", "3", "4"}, values, "parallelism=%d", parallelism)
+		require.Equal(t, []string{"first", "second", "group3"}, groups, "parallelism=%d", parallelism)
+	}
+}
+
+func TestExecuterExecuteGroups__CollectErrors(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	e.SetParallelism(2)
+	e.SetCollectGroupErrors(true)
+
+	var statements []int
+	e.SetGroupHook(func(result *mysqlbatch.GroupResult) {
+		statements = append(statements, result.Statements)
+	})
+	err = e.ExecuteGroups([]mysqlbatch.QueryGroup{
+		{Name: "a", Reader: strings.NewReader("SELECT 1; SELECT * FROM mysqlbatch.not_exists_a;")},
+		{Name: "b", Reader: strings.NewReader("SELECT 1; SELECT 2;")},
+		{Name: "c", Reader: strings.NewReader("SELECT * FROM mysqlbatch.not_exists_c;")},
+	}, nil)
+	var groupErrs mysqlbatch.GroupErrors
+	require.True(t, errors.As(err, &groupErrs))
+	require.Len(t, groupErrs, 2)
+	require.Equal(t, "a", groupErrs[0].Name)
+	require.Equal(t, "c", groupErrs[1].Name)
+	require.Equal(t, []int{1, 2, 0}, statements)
+}
@@ -0,0 +1,240 @@
+package mysqlbatch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Songmu/flextime"
+	"github.com/pkg/errors"
+)
+
+// History statuses
+const (
+	HistoryStatusSuccess = "success"
+	HistoryStatusFailed  = "failed"
+	HistoryStatusSkipped = "skipped"
+)
+
+// History is a record of a batch execution
+type History struct {
+	JobName    string              `json:"job_name"`
+	SQLHash    string              `json:"sql_hash"`
+	Vars       map[string]string   `json:"vars,omitempty"`
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt time.Time           `json:"finished_at"`
+	Statements []*StatementHistory `json:"statements,omitempty"`
+	Status     string              `json:"status"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// StatementHistory is a record of an executed statement
+type StatementHistory struct {
+	Query        string `json:"query"`
+	RowsAffected int64  `json:"rows_affected"`
+}
+
+// HistoryRecorder records History of every batch execution
+type HistoryRecorder interface {
+	RecordHistory(ctx context.Context, h *History) error
+}
+
+// SetHistoryRecorder set HistoryRecorder and the job name recorded in History
+func (e *Executer) SetHistoryRecorder(recorder HistoryRecorder, jobName string) {
+	e.historyRecorder = recorder
+	e.jobName = jobName
+}
+
+// execution is the state of the current batch execution
+type execution struct {
+	mu         sync.Mutex
+	sqlHash    hash.Hash
+	statements []*StatementHistory
+	// statementIndex is the index of the next statement in the whole batch
+	statementIndex int
+	// resumeFrom is the index of the first statement not completed in the previous execution
+	resumeFrom int
+	// transactions is whether each connection is in a transaction
+	transactions map[queryer]bool
+	// sessionChange is the state left on the connections by the statements
+	sessionChange sessionChange
+}
+
+func (ex *execution) addSessionChange(change sessionChange) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.sessionChange = max(ex.sessionChange, change)
+}
+
+func (ex *execution) addRendered(rendered string) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.sqlHash.Write([]byte(rendered))
+}
+
+func (ex *execution) addStatement(query string, rowsAffected int64) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.statements = append(ex.statements, &StatementHistory{
+		Query:        query,
+		RowsAffected: rowsAffected,
+	})
+}
+
+// nextStatement returns the index of the next statement, and whether it was completed in the previous execution
+func (ex *execution) nextStatement() (int, bool) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	index := ex.statementIndex
+	ex.statementIndex++
+	return index, index < ex.resumeFrom
+}
+
+func (ex *execution) hash() string {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	return hex.EncodeToString(ex.sqlHash.Sum(nil))
+}
+
+func (e *Executer) recordHistory(ctx context.Context, vars map[string]string, startedAt time.Time, err error) {
+	h := &History{
+		JobName:    e.jobName,
+		SQLHash:    e.current.hash(),
+		StartedAt:  startedAt,
+		FinishedAt: e.lastExecuteTime,
+		Statements: e.current.statements,
+		Status:     HistoryStatusSuccess,
+	}
+	if len(vars) > 0 {
+		h.Vars = make(map[string]string, len(vars))
+		for key, value := range vars {
+			h.Vars[key] = e.redactor.Redact(value)
+		}
+	}
+	if e.lastSkipped {
+		h.Status = HistoryStatusSkipped
+	}
+	if err != nil {
+		h.Status = HistoryStatusFailed
+		h.Error = err.Error()
+		h.FinishedAt = e.dbTime(ctx)
+	}
+	// the batch result is not changed by the failure of recording
+	if err := e.historyRecorder.RecordHistory(context.WithoutCancel(ctx), h); err != nil {
+		log.Printf("record history failed: %s", err)
+	}
+}
+
+// dbTime returns the time on DB, or local time if failed
+func (e *Executer) dbTime(ctx context.Context) time.Time {
+	var t time.Time
+	if err := e.db.QueryRowContext(context.WithoutCancel(ctx), e.timeCheckQuery).Scan(&t); err != nil {
+		return flextime.Now()
+	}
+	return t
+}
+
+// TableHistoryRecorder records History into the table, creating it if missing
+type TableHistoryRecorder struct {
+	db       *sql.DB
+	table    string
+	mu       sync.Mutex
+	created  bool
+	resolved bool
+}
+
+// NewTableHistoryRecorder returns TableHistoryRecorder. table can be qualified by database name as `db.table`.
+// An unqualified table is resolved by the current database of db before the first execution of Executer,
+// so that USE in the batch does not move the table.
+func NewTableHistoryRecorder(db *sql.DB, table string) *TableHistoryRecorder {
+	return &TableHistoryRecorder{
+		db:       db,
+		table:    quoteIdentifier(table),
+		resolved: strings.Contains(table, "."),
+	}
+}
+
+// tableResolver resolves the unqualified table before the execution changes the database by USE
+type tableResolver interface {
+	resolveTable(ctx context.Context) error
+}
+
+func (r *TableHistoryRecorder) resolveTable(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.resolved {
+		return nil
+	}
+	var database sql.NullString
+	if err := r.db.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&database); err != nil {
+		return errors.Wrap(err, "select current database")
+	}
+	if database.String != "" {
+		r.table = "`" + strings.ReplaceAll(database.String, "`", "``") + "`." + r.table
+	}
+	r.resolved = true
+	return nil
+}
+
+// RecordHistory inserts a row of History
+func (r *TableHistoryRecorder) RecordHistory(ctx context.Context, h *History) error {
+	table, err := r.createTable(ctx)
+	if err != nil {
+		return err
+	}
+	vars, err := json.Marshal(h.Vars)
+	if err != nil {
+		return err
+	}
+	statements, err := json.Marshal(h.Statements)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (job_name, sql_hash, vars, started_at, finished_at, statements, status, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", table),
+		h.JobName, h.SQLHash, string(vars), h.StartedAt, h.FinishedAt, string(statements), h.Status, h.Error,
+	)
+	return errors.Wrap(err, "insert history")
+}
+
+// createTable creates the table if not yet, and returns the quoted table
+func (r *TableHistoryRecorder) createTable(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.created {
+		return r.table, nil
+	}
+	_, err := r.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    job_name VARCHAR(191) NOT NULL,
+    sql_hash CHAR(64) NOT NULL,
+    vars JSON,
+    started_at DATETIME(6) NOT NULL,
+    finished_at DATETIME(6) NOT NULL,
+    statements JSON,
+    status VARCHAR(16) NOT NULL,
+    error TEXT,
+    PRIMARY KEY (id),
+    INDEX idx_job_name_started_at (job_name, started_at)
+)`, r.table))
+	if err != nil {
+		return "", errors.Wrap(err, "create history table")
+	}
+	r.created = true
+	return r.table, nil
+}
+
+func quoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = "`" + strings.ReplaceAll(strings.Trim(part, "`"), "`", "``") + "`"
+	}
+	return strings.Join(parts, ".")
+}
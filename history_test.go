@@ -0,0 +1,164 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+type historyRecorderFunc func(ctx context.Context, h *mysqlbatch.History) error
+
+func (f historyRecorderFunc) RecordHistory(ctx context.Context, h *mysqlbatch.History) error {
+	return f(ctx, h)
+}
+
+func TestExecuterExecute__WithHistoryRecorder(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(testChunkSetupSQL), nil))
+
+	var histories []*mysqlbatch.History
+	e.SetHistoryRecorder(historyRecorderFunc(func(_ context.Context, h *mysqlbatch.History) error {
+		histories = append(histories, h)
+		return nil
+	}), "purge")
+	e.Redactor().AddVarNames("token")
+	err = e.Execute(strings.NewReader("USE mysqlbatch; DELETE FROM chunk_events WHERE created_at < '{{ var(\"cutoff\", \"\") }}';"), map[string]string{
+		"cutoff": "2023-01-01",
+		"token":  "secret",
+	})
+	require.NoError(t, err)
+	err = e.Execute(strings.NewReader("SELECT * FROM mysqlbatch.not_exists;"), nil)
+	require.Error(t, err)
+
+	require.Len(t, histories, 2)
+	h := histories[0]
+	require.Equal(t, "purge", h.JobName)
+	require.Equal(t, mysqlbatch.HistoryStatusSuccess, h.Status)
+	require.Len(t, h.SQLHash, 64)
+	require.Equal(t, map[string]string{"cutoff": "2023-01-01", "token": "********"}, h.Vars)
+	require.Equal(t, e.LastExecuteTime(), h.FinishedAt)
+	require.False(t, h.StartedAt.After(h.FinishedAt))
+	require.Equal(t, []*mysqlbatch.StatementHistory{
+		{Query: "USE mysqlbatch", RowsAffected: 0},
+		{Query: "DELETE FROM chunk_events WHERE created_at < '2023-01-01'", RowsAffected: 25},
+	}, h.Statements)
+
+	h = histories[1]
+	require.Equal(t, mysqlbatch.HistoryStatusFailed, h.Status)
+	require.Equal(t, err.Error(), h.Error)
+	require.Empty(t, h.Statements)
+}
+
+func TestTableHistoryRecorder(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader("CREATE DATABASE IF NOT EXISTS mysqlbatch; DROP TABLE IF EXISTS mysqlbatch.mysqlbatch_history;"), nil))
+
+	e.SetHistoryRecorder(mysqlbatch.NewTableHistoryRecorder(e.DB(), "mysqlbatch.mysqlbatch_history"), "test")
+	require.NoError(t, e.Execute(strings.NewReader("SELECT 1;"), nil))
+	require.Error(t, e.Execute(strings.NewReader("SELECT * FROM mysqlbatch.not_exists;"), nil))
+
+	var rows [][]string
+	e.SetSelectHook(func(query string, columns []string, r [][]string) {
+		rows = r
+	})
+	require.NoError(t, e.Execute(strings.NewReader("SELECT job_name, status FROM mysqlbatch.mysqlbatch_history ORDER BY id;"), nil))
+	require.Equal(t, [][]string{{"test", "success"}, {"test", "failed"}}, rows)
+}
+
+func TestTableHistoryRecorder__FailedInTransaction(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader("CREATE DATABASE IF NOT EXISTS mysqlbatch; DROP TABLE IF EXISTS mysqlbatch.mysqlbatch_history;"), nil))
+
+	e.SetHistoryRecorder(mysqlbatch.NewTableHistoryRecorder(e.DB(), "mysqlbatch.mysqlbatch_history"), "test")
+	require.NoError(t, e.Execute(strings.NewReader("SELECT 1;"), nil))
+	require.Error(t, e.Execute(strings.NewReader("START TRANSACTION; SELECT * FROM mysqlbatch.not_exists;"), nil))
+	require.NoError(t, e.Close())
+
+	// the failed history is not rolled back with the transaction left open by the batch
+	other, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer other.Close()
+	var rows [][]string
+	other.SetSelectHook(func(query string, columns []string, r [][]string) {
+		rows = r
+	})
+	require.NoError(t, other.Execute(strings.NewReader("SELECT job_name, status FROM mysqlbatch.mysqlbatch_history ORDER BY id;"), nil))
+	require.Equal(t, [][]string{{"test", "success"}, {"test", "failed"}}, rows)
+}
+
+func TestTableHistoryRecorder__WithUse(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	setup, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer setup.Close()
+	require.NoError(t, setup.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+CREATE DATABASE IF NOT EXISTS mysqlbatch_other;
+DROP TABLE IF EXISTS mysqlbatch.use_history;
+DROP TABLE IF EXISTS mysqlbatch_other.use_history;
+`), nil))
+
+	conf.Database = "mysqlbatch"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	e.SetHistoryRecorder(mysqlbatch.NewTableHistoryRecorder(e.DB(), "use_history"), "test")
+	require.NoError(t, e.Execute(strings.NewReader("USE mysqlbatch_other;\nSELECT 1;"), nil))
+	require.NoError(t, e.Execute(strings.NewReader("SELECT 1;"), nil))
+
+	// the unqualified table is resolved by the database before USE in the batch
+	var rows [][]string
+	setup.SetSelectHook(func(query string, columns []string, r [][]string) {
+		rows = r
+	})
+	require.NoError(t, setup.Execute(strings.NewReader("SELECT job_name, status FROM mysqlbatch.use_history ORDER BY id;"), nil))
+	require.Equal(t, [][]string{{"test", "success"}, {"test", "success"}}, rows)
+	require.NoError(t, setup.Execute(strings.NewReader("SHOW TABLES FROM mysqlbatch_other LIKE 'use_history';"), nil))
+	require.Empty(t, rows)
+}
+
+func TestExecuterExecute__TransactionLeftOpen(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+DROP TABLE IF EXISTS mysqlbatch.left_open;
+CREATE TABLE mysqlbatch.left_open (id INT PRIMARY KEY);
+`), nil))
+
+	// the transaction left open by the batch is rolled back, so COMMIT in the next batch does not commit it
+	require.NoError(t, e.Execute(strings.NewReader("START TRANSACTION; INSERT INTO mysqlbatch.left_open VALUES (1);"), nil))
+	require.NoError(t, e.Execute(strings.NewReader("COMMIT;"), nil))
+	require.NoError(t, e.Execute(strings.NewReader("START TRANSACTION; INSERT INTO mysqlbatch.left_open VALUES (2); COMMIT;"), nil))
+	require.NoError(t, e.Execute(strings.NewReader("INSERT INTO mysqlbatch.left_open VALUES (3);"), nil))
+
+	var rows [][]string
+	e.SetSelectHook(func(query string, columns []string, r [][]string) {
+		rows = r
+	})
+	require.NoError(t, e.Execute(strings.NewReader("SELECT id FROM mysqlbatch.left_open ORDER BY id;"), nil))
+	require.Equal(t, [][]string{{"2"}, {"3"}}, rows)
+}
@@ -0,0 +1,163 @@
+package mysqlbatch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultLagCheckInterval is the interval of checking replica lag while waiting
+const DefaultLagCheckInterval = 5 * time.Second
+
+// LagChecker returns the current replication lag
+type LagChecker interface {
+	ReplicaLag(ctx context.Context) (time.Duration, error)
+}
+
+// ReplicaStatusLagChecker checks replication lag by SHOW REPLICA STATUS on the replica
+type ReplicaStatusLagChecker struct {
+	db *sql.DB
+}
+
+// NewReplicaStatusLagChecker returns LagChecker with *sql.DB connected to the replica
+func NewReplicaStatusLagChecker(db *sql.DB) *ReplicaStatusLagChecker {
+	return &ReplicaStatusLagChecker{db: db}
+}
+
+// ReplicaLag returns Seconds_Behind_Source (Seconds_Behind_Master) of the replica.
+// If the replica has multiple sources, returns the maximum.
+func (c *ReplicaStatusLagChecker) ReplicaLag(ctx context.Context) (time.Duration, error) {
+	rows, err := c.db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		// before MySQL 8.0.22
+		var err2 error
+		rows, err2 = c.db.QueryContext(ctx, "SHOW SLAVE STATUS")
+		if err2 != nil {
+			return 0, fmt.Errorf("show replica status: %w, show slave status: %w", err, err2)
+		}
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	lagIndex := -1
+	for i, column := range columns {
+		if column == "Seconds_Behind_Source" || column == "Seconds_Behind_Master" {
+			lagIndex = i
+		}
+	}
+	if lagIndex < 0 {
+		return 0, errors.New("replica status has no Seconds_Behind_Source column")
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var maxLag time.Duration
+	var found bool
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return 0, err
+		}
+		if !values[lagIndex].Valid {
+			return 0, errors.New("replication is not running")
+		}
+		seconds, err := strconv.ParseInt(values[lagIndex].String, 10, 64)
+		if err != nil {
+			return 0, errors.Wrap(err, "parse Seconds_Behind_Source")
+		}
+		found = true
+		if lag := time.Duration(seconds) * time.Second; lag > maxLag {
+			maxLag = lag
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, errors.New("not a replica")
+	}
+	return maxLag, nil
+}
+
+// AuroraLagChecker checks replication lag of Aurora MySQL replicas by information_schema.replica_host_status
+type AuroraLagChecker struct {
+	db *sql.DB
+}
+
+// NewAuroraLagChecker returns LagChecker with *sql.DB connected to any instance of the Aurora cluster
+func NewAuroraLagChecker(db *sql.DB) *AuroraLagChecker {
+	return &AuroraLagChecker{db: db}
+}
+
+// ReplicaLag returns the maximum lag of the Aurora replicas
+func (c *AuroraLagChecker) ReplicaLag(ctx context.Context) (time.Duration, error) {
+	var lag sql.NullFloat64
+	err := c.db.QueryRowContext(ctx,
+		"SELECT MAX(replica_lag_in_milliseconds) FROM information_schema.replica_host_status WHERE session_id != 'MASTER_SESSION_ID'",
+	).Scan(&lag)
+	if err != nil {
+		return 0, errors.Wrap(err, "get aurora replica lag")
+	}
+	return time.Duration(lag.Float64 * float64(time.Millisecond)), nil
+}
+
+type lagThrottle struct {
+	checker  LagChecker
+	maxLag   time.Duration
+	interval time.Duration
+}
+
+// SetLagThrottle set the throttle that pauses before each statement and chunk while the replica lag exceeds maxLag.
+// While waiting, the lag is checked every interval.
+func (e *Executer) SetLagThrottle(checker LagChecker, maxLag time.Duration, interval time.Duration) {
+	if checker == nil {
+		e.lagThrottle = nil
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultLagCheckInterval
+	}
+	e.lagThrottle = &lagThrottle{
+		checker:  checker,
+		maxLag:   maxLag,
+		interval: interval,
+	}
+}
+
+func (e *Executer) waitForReplicaLag(ctx context.Context) error {
+	if e.lagThrottle == nil {
+		return nil
+	}
+	return e.lagThrottle.wait(ctx)
+}
+
+func (t *lagThrottle) wait(ctx context.Context) error {
+	var start time.Time
+	for {
+		lag, err := t.checker.ReplicaLag(ctx)
+		if err != nil {
+			return fmt.Errorf("check replica lag: %w", err)
+		}
+		if lag <= t.maxLag {
+			if !start.IsZero() {
+				log.Printf("replica lag is %s, resumed after waiting %s", lag, time.Since(start).Round(time.Millisecond))
+			}
+			return nil
+		}
+		if start.IsZero() {
+			start = time.Now()
+		}
+		log.Printf("replica lag %s exceeds %s, waiting", lag, t.maxLag)
+		if err := sleepContext(ctx, t.interval); err != nil {
+			return fmt.Errorf("waiting for replica lag: %w", err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+type stubLagChecker struct {
+	calls int32
+	lags  []time.Duration
+	err   error
+}
+
+func (c *stubLagChecker) ReplicaLag(_ context.Context) (time.Duration, error) {
+	i := atomic.AddInt32(&c.calls, 1) - 1
+	if c.err != nil {
+		return 0, c.err
+	}
+	if int(i) < len(c.lags) {
+		return c.lags[i], nil
+	}
+	return 0, nil
+}
+
+func TestExecuterExecute__WithLagThrottle(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	checker := &stubLagChecker{
+		lags: []time.Duration{0, 20 * time.Second, 15 * time.Second, time.Second},
+	}
+	e.SetLagThrottle(checker, 10*time.Second, 10*time.Millisecond)
+	var queries []string
+	e.SetSelectHook(func(query string, columns []string, rows [][]string) {
+		queries = append(queries, query)
+	})
+	err = e.Execute(strings.NewReader("SELECT 1; SELECT 2; SELECT 3;"), nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"SELECT 1", "SELECT 2", "SELECT 3"}, queries)
+	require.EqualValues(t, 5, checker.calls)
+
+	checkErr := errors.New("replication is not running")
+	e.SetLagThrottle(&stubLagChecker{err: checkErr}, 10*time.Second, 10*time.Millisecond)
+	err = e.Execute(strings.NewReader("SELECT 1;"), nil)
+	require.ErrorIs(t, err, checkErr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	e.SetLagThrottle(&stubLagChecker{lags: []time.Duration{time.Minute, time.Minute, time.Minute, time.Minute, time.Minute, time.Minute, time.Minute, time.Minute}}, 10*time.Second, 20*time.Millisecond)
+	err = e.ExecuteContext(ctx, strings.NewReader("SELECT 1;"), nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestReplicaStatusLagChecker__BothErrors(t *testing.T) {
+	db, err := sql.Open("mysql", "root:mysqlbatch@tcp(127.0.0.1:3306)/")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	_, err = mysqlbatch.NewReplicaStatusLagChecker(db).ReplicaLag(context.Background())
+	require.EqualError(t, err, "show replica status: sql: database is closed, show slave status: sql: database is closed")
+}
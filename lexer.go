@@ -0,0 +1,138 @@
+package mysqlbatch
+
+import (
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenIdentifier
+	tokenSymbol
+)
+
+// token is a lexical token of SQL, depth is the nesting level of parentheses
+type token struct {
+	kind  tokenKind
+	text  string
+	depth int
+}
+
+// keyword returns the upper-cased text if the token is a word, otherwise empty
+func (t token) keyword() string {
+	if t.kind != tokenWord {
+		return ""
+	}
+	return strings.ToUpper(t.text)
+}
+
+// tokenize splits the query into tokens, skipping whitespaces and comments.
+// The content of executable comments (/*! */) is tokenized as a part of the query.
+func tokenize(query string) []token {
+	var tokens []token
+	depth := 0
+	inExecutable := false
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#' || strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+		case strings.HasPrefix(query[i:], "/*!"):
+			i += 3
+			for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+				i++
+			}
+			inExecutable = true
+		case inExecutable && strings.HasPrefix(query[i:], "*/"):
+			i += 2
+			inExecutable = false
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '\'' || c == '"' || c == '`':
+			end := scanQuoted(query, i)
+			kind := tokenString
+			if c == '`' {
+				kind = tokenIdentifier
+			}
+			tokens = append(tokens, token{kind: kind, text: query[i:end], depth: depth})
+			i = end
+		case isWordChar(c):
+			end := i + 1
+			for end < len(query) && isWordChar(query[end]) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: query[i:end], depth: depth})
+			i = end
+		default:
+			if c == ')' && depth > 0 {
+				depth--
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: query[i : i+1], depth: depth})
+			if c == '(' {
+				depth++
+			}
+			i++
+		}
+	}
+	return tokens
+}
+
+// statementVerb returns the upper-cased first keyword of the query.
+// For WITH, returns the keyword of the statement after common table expressions.
+// Leading parentheses like (SELECT ...) are skipped.
+func statementVerb(tokens []token) string {
+	for len(tokens) > 0 && tokens[0].text == "(" {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return ""
+	}
+	verb := tokens[0].keyword()
+	if verb != "WITH" {
+		return verb
+	}
+	depth := tokens[0].depth
+	for _, t := range tokens[1:] {
+		if kw := t.keyword(); t.depth == depth && (kw == "DELETE" || kw == "UPDATE" || kw == "SELECT") {
+			return kw
+		}
+	}
+	return verb
+}
+
+// scanQuoted returns the end of the quoted string starting at start
+func scanQuoted(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c == '@' || c == '.' ||
+		(c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
@@ -0,0 +1,121 @@
+package mysqlbatch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LockMode is the behavior when the named lock is held by another session
+type LockMode string
+
+const (
+	// LockModeFail fails immediately if the lock is held
+	LockModeFail LockMode = "fail"
+	// LockModeWait waits for the lock until the timeout, and fails if the lock is not acquired
+	LockModeWait LockMode = "wait"
+	// LockModeSkip skips the execution successfully if the lock is held
+	LockModeSkip LockMode = "skip"
+)
+
+// ErrLockNotAcquired is returned when the named lock is held by another session
+var ErrLockNotAcquired = errors.New("lock is held by another session")
+
+// ParseLockMode parses fail, wait or skip
+func ParseLockMode(s string) (LockMode, error) {
+	switch mode := LockMode(strings.ToLower(s)); mode {
+	case LockModeFail, LockModeWait, LockModeSkip:
+		return mode, nil
+	case "":
+		return LockModeFail, nil
+	default:
+		return "", errors.Errorf("unknown lock mode `%s`, must be fail, wait or skip", s)
+	}
+}
+
+type namedLock struct {
+	name    string
+	mode    LockMode
+	timeout time.Duration
+}
+
+// SetLock set the named lock acquired by GET_LOCK() on the executer connection before the execution.
+// name is a template rendered with the same variables as SQL.
+// In LockModeWait, timeout <= 0 means waiting forever.
+// If name is empty, the lock is disabled.
+func (e *Executer) SetLock(name string, mode LockMode, timeout time.Duration) {
+	if name == "" {
+		e.lock = nil
+		return
+	}
+	e.lock = &namedLock{
+		name:    name,
+		mode:    mode,
+		timeout: timeout,
+	}
+}
+
+// LastSkipped returns true if the last execution was skipped because the lock was held in LockModeSkip
+func (e *Executer) LastSkipped() bool {
+	return e.lastSkipped
+}
+
+// acquireLock acquires the named lock on conn.
+// If the lock is held in LockModeSkip, returns skipped = true.
+func (e *Executer) acquireLock(ctx context.Context, conn *sql.Conn, vars map[string]string) (release func(), skipped bool, err error) {
+	release = func() {}
+	if e.lock == nil {
+		return release, false, nil
+	}
+	tpl, err := parseTemplate([]byte(e.lock.name))
+	if err != nil {
+		return nil, false, errors.Wrap(err, "parse lock name template failed")
+	}
+	name, err := tpl.Execute(e.newPongo2Ctx(ctx, vars))
+	if err != nil {
+		return nil, false, errors.Wrap(err, "execute lock name template failed")
+	}
+	return getLock(ctx, conn, name, e.lock.mode, e.lock.timeout)
+}
+
+// getLock acquires the named lock by GET_LOCK() on conn, and returns the function releasing it.
+// If the lock is held in LockModeSkip, returns skipped = true.
+func getLock(ctx context.Context, conn *sql.Conn, name string, mode LockMode, timeout time.Duration) (release func(), skipped bool, err error) {
+	var seconds int64
+	if mode == LockModeWait {
+		seconds = -1
+		if timeout > 0 {
+			seconds = int64(math.Ceil(timeout.Seconds()))
+		}
+	}
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, seconds).Scan(&acquired); err != nil {
+		return nil, false, fmt.Errorf("get lock `%s`: %w", name, err)
+	}
+	if !acquired.Valid {
+		return nil, false, fmt.Errorf("get lock `%s`: GET_LOCK() returned NULL", name)
+	}
+	if acquired.Int64 != 1 {
+		if mode == LockModeSkip {
+			log.Printf("lock `%s` is held by another session, skip execution", name)
+			return nil, true, nil
+		}
+		return nil, false, fmt.Errorf("get lock `%s`: %w", name, ErrLockNotAcquired)
+	}
+	release = func() {
+		// release even if ctx is canceled
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var released sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", name).Scan(&released); err != nil {
+			log.Printf("release lock `%s` failed: %s", name, err)
+		}
+	}
+	return release, false, nil
+}
@@ -0,0 +1,72 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuterExecute__WithLock(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	holder, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer holder.Close()
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	var count int
+	e.SetSelectHook(func(query string, columns []string, rows [][]string) {
+		count++
+	})
+	vars := map[string]string{"tenant": "foo"}
+	started := make(chan struct{})
+	done := make(chan error)
+	holder.SetLock(`mysqlbatch-test-{{ var("tenant", "") }}`, mysqlbatch.LockModeFail, 0)
+	var once sync.Once
+	holder.SetSelectHook(func(query string, columns []string, rows [][]string) {
+		once.Do(func() {
+			close(started)
+		})
+	})
+	go func() {
+		done <- holder.Execute(strings.NewReader("SELECT 1; SELECT SLEEP(1);"), vars)
+	}()
+	<-started
+
+	e.SetLock("mysqlbatch-test-foo", mysqlbatch.LockModeFail, 0)
+	err = e.Execute(strings.NewReader("SELECT 1;"), vars)
+	require.ErrorIs(t, err, mysqlbatch.ErrLockNotAcquired)
+	require.False(t, e.LastSkipped())
+
+	e.SetLock(`mysqlbatch-test-{{ var("tenant", "") }}`, mysqlbatch.LockModeSkip, 0)
+	err = e.Execute(strings.NewReader("SELECT 1;"), vars)
+	require.NoError(t, err)
+	require.True(t, e.LastSkipped())
+	require.Equal(t, 0, count)
+
+	e.SetLock(`mysqlbatch-test-{{ var("tenant", "") }}`, mysqlbatch.LockModeWait, 5*time.Second)
+	err = e.Execute(strings.NewReader("SELECT 1;"), vars)
+	require.NoError(t, err)
+	require.False(t, e.LastSkipped())
+	require.Equal(t, 1, count)
+	require.NoError(t, <-done)
+}
+
+func TestParseLockMode(t *testing.T) {
+	mode, err := mysqlbatch.ParseLockMode("")
+	require.NoError(t, err)
+	require.Equal(t, mysqlbatch.LockModeFail, mode)
+	mode, err = mysqlbatch.ParseLockMode("SKIP")
+	require.NoError(t, err)
+	require.Equal(t, mysqlbatch.LockModeSkip, mode)
+	_, err = mysqlbatch.ParseLockMode("retry")
+	require.Error(t, err)
+}
@@ -0,0 +1,401 @@
+package mysqlbatch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMigrationTable is the table name tracking applied migrations
+const DefaultMigrationTable = "schema_migrations"
+
+// DefaultMigrationLockTimeout is the timeout of waiting for another migrator of the same table
+const DefaultMigrationLockTimeout = time.Minute
+
+// migrationFilePattern matches `0001_create_users.up.sql`, `0001_create_users.down.sql` and `0001_create_users.sql`
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+?)(?:\.(up|down))?\.sql$`)
+
+// Migration is a versioned SQL file pair
+type Migration struct {
+	Version  int64
+	Name     string
+	UpFile   string
+	DownFile string
+	Checksum string
+}
+
+// MigrationStatus is the state of the migration
+type MigrationStatus struct {
+	Version   int64     `json:"version"`
+	Name      string    `json:"name"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+	// Modified is true if the applied file was changed after it was applied
+	Modified bool `json:"modified,omitempty"`
+	// Missing is true if the applied migration has no file
+	Missing bool `json:"missing,omitempty"`
+}
+
+// Migrator applies numbered SQL files in order by Executer, tracking applied versions and checksums in the table
+type Migrator struct {
+	executer    *Executer
+	source      fs.FS
+	table       string
+	lockTimeout time.Duration
+	// resolved is the quoted table qualified by the database at the first use
+	resolved string
+}
+
+// NewMigrator returns Migrator with migration files in source (e.g. os.DirFS or embed.FS)
+func NewMigrator(e *Executer, source fs.FS) *Migrator {
+	return &Migrator{
+		executer:    e,
+		source:      source,
+		table:       DefaultMigrationTable,
+		lockTimeout: DefaultMigrationLockTimeout,
+	}
+}
+
+// SetTable set the table name tracking applied migrations
+func (m *Migrator) SetTable(table string) {
+	m.table = table
+	m.resolved = ""
+}
+
+// SetLockTimeout set the timeout of waiting for another migrator of the same table. timeout <= 0 means waiting forever.
+func (m *Migrator) SetLockTimeout(timeout time.Duration) {
+	m.lockTimeout = timeout
+}
+
+// lock acquires the named lock of the table on a dedicated connection, so that concurrent migrators do not apply the same version.
+// The pool of the executer is extended by the connection while locked.
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	db := m.executer.db
+	maxOpen := db.Stats().MaxOpenConnections
+	if maxOpen > 0 {
+		db.SetMaxOpenConns(maxOpen + 1)
+	}
+	restore := func() {
+		if maxOpen > 0 {
+			db.SetMaxOpenConns(maxOpen)
+		}
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		restore()
+		return nil, errors.Wrap(err, "get db connection for migration lock")
+	}
+	release, _, err := getLock(ctx, conn, migrationLockName(m.table), LockModeWait, m.lockTimeout)
+	if err != nil {
+		conn.Close()
+		restore()
+		return nil, errors.Wrap(err, "migration lock")
+	}
+	return func() {
+		release()
+		conn.Close()
+		restore()
+	}, nil
+}
+
+// migrationLockName returns the lock name of the table within 64 characters of GET_LOCK()
+func migrationLockName(table string) string {
+	name := "mysqlbatch-migrate:" + table
+	if len(name) > 64 {
+		sum := sha256.Sum256([]byte(table))
+		name = "mysqlbatch-migrate:" + hex.EncodeToString(sum[:])[:40]
+	}
+	return name
+}
+
+type appliedMigration struct {
+	version   int64
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Status returns the states of all migrations ordered by version
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	table, err := m.resolveTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.status(ctx, table)
+}
+
+// resolveTable returns the quoted table. An unqualified table is qualified by the current database at the first use,
+// so that USE in the migrations does not move the table.
+func (m *Migrator) resolveTable(ctx context.Context) (string, error) {
+	if m.resolved != "" {
+		return m.resolved, nil
+	}
+	table := quoteIdentifier(m.table)
+	if !strings.Contains(m.table, ".") {
+		var database sql.NullString
+		if err := m.executer.db.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&database); err != nil {
+			return "", errors.Wrap(err, "select current database")
+		}
+		if database.String != "" {
+			table = "`" + strings.ReplaceAll(database.String, "`", "``") + "`." + table
+		}
+	}
+	m.resolved = table
+	return table, nil
+}
+
+func (m *Migrator) status(ctx context.Context, table string) ([]*MigrationStatus, error) {
+	migrations, err := m.migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[int64]*MigrationStatus, len(migrations))
+	for _, mig := range migrations {
+		statuses[mig.Version] = &MigrationStatus{
+			Version: mig.Version,
+			Name:    mig.Name,
+		}
+	}
+	byVersion := make(map[int64]*Migration, len(migrations))
+	for _, mig := range migrations {
+		byVersion[mig.Version] = mig
+	}
+	for _, a := range applied {
+		status, ok := statuses[a.version]
+		if !ok {
+			status = &MigrationStatus{Version: a.version, Name: a.name, Missing: true}
+			statuses[a.version] = status
+		} else {
+			status.Modified = byVersion[a.version].Checksum != a.checksum
+		}
+		status.Applied = true
+		status.AppliedAt = a.appliedAt
+	}
+	list := make([]*MigrationStatus, 0, len(statuses))
+	for _, status := range statuses {
+		list = append(list, status)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list, nil
+}
+
+// Up applies n pending migrations in order. If n <= 0, applies all pending migrations.
+// Returns an error without applying anything if an applied file was modified or is missing.
+// Migrators of the same table are serialized by the named lock.
+func (m *Migrator) Up(ctx context.Context, n int, vars map[string]string) ([]*Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	table, err := m.resolveTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := m.migrations()
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := m.status(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	appliedVersions := make(map[int64]bool, len(statuses))
+	for _, status := range statuses {
+		if status.Modified {
+			return nil, errors.Errorf("migration %d_%s was modified after applied", status.Version, status.Name)
+		}
+		if status.Missing {
+			return nil, errors.Errorf("migration %d_%s was applied, but the file is missing", status.Version, status.Name)
+		}
+		appliedVersions[status.Version] = status.Applied
+	}
+	var applied []*Migration
+	for _, mig := range migrations {
+		if appliedVersions[mig.Version] {
+			continue
+		}
+		if n > 0 && len(applied) >= n {
+			break
+		}
+		if err := m.execute(ctx, mig.UpFile, vars); err != nil {
+			return applied, errors.Wrapf(err, "migration %d_%s up", mig.Version, mig.Name)
+		}
+		if _, err := m.executer.db.ExecContext(ctx,
+			fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)", table),
+			mig.Version, mig.Name, mig.Checksum, m.executer.LastExecuteTime(),
+		); err != nil {
+			return applied, errors.Wrapf(err, "record migration %d_%s", mig.Version, mig.Name)
+		}
+		applied = append(applied, mig)
+	}
+	return applied, nil
+}
+
+// Down rolls back n latest applied migrations by the paired down files. If n <= 0, rolls back one.
+// Migrators of the same table are serialized by the named lock.
+func (m *Migrator) Down(ctx context.Context, n int, vars map[string]string) ([]*Migration, error) {
+	if n <= 0 {
+		n = 1
+	}
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	table, err := m.resolveTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := m.migrations()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration, len(migrations))
+	for _, mig := range migrations {
+		byVersion[mig.Version] = mig
+	}
+	applied, err := m.applied(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	var rolledBack []*Migration
+	for i := len(applied) - 1; i >= 0 && len(rolledBack) < n; i-- {
+		a := applied[i]
+		mig, ok := byVersion[a.version]
+		if !ok {
+			return rolledBack, errors.Errorf("migration %d_%s was applied, but the file is missing", a.version, a.name)
+		}
+		if mig.Checksum != a.checksum {
+			return rolledBack, errors.Errorf("migration %d_%s was modified after applied", mig.Version, mig.Name)
+		}
+		if mig.DownFile == "" {
+			return rolledBack, errors.Errorf("migration %d_%s has no down file", mig.Version, mig.Name)
+		}
+		if err := m.execute(ctx, mig.DownFile, vars); err != nil {
+			return rolledBack, errors.Wrapf(err, "migration %d_%s down", mig.Version, mig.Name)
+		}
+		if _, err := m.executer.db.ExecContext(ctx,
+			fmt.Sprintf("DELETE FROM %s WHERE version = ?", table),
+			mig.Version,
+		); err != nil {
+			return rolledBack, errors.Wrapf(err, "delete migration record %d_%s", mig.Version, mig.Name)
+		}
+		rolledBack = append(rolledBack, mig)
+	}
+	return rolledBack, nil
+}
+
+func (m *Migrator) execute(ctx context.Context, file string, vars map[string]string) error {
+	bs, err := fs.ReadFile(m.source, file)
+	if err != nil {
+		return err
+	}
+	return m.executer.ExecuteContext(ctx, bytes.NewReader(bs), vars)
+}
+
+// migrations returns migrations in source ordered by version
+func (m *Migrator) migrations() ([]*Migration, error) {
+	entries, err := fs.ReadDir(m.source, ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "read migrations")
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse version of %s", entry.Name())
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != match[2] {
+			return nil, errors.Errorf("migration version %d is duplicated: %s and %s", version, mig.Name, match[2])
+		}
+		switch match[3] {
+		case "down":
+			if mig.DownFile != "" {
+				return nil, errors.Errorf("migration version %d has multiple down files", version)
+			}
+			mig.DownFile = entry.Name()
+		default:
+			if mig.UpFile != "" {
+				return nil, errors.Errorf("migration version %d has multiple up files", version)
+			}
+			mig.UpFile = entry.Name()
+		}
+	}
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.UpFile == "" {
+			return nil, errors.Errorf("migration %d_%s has no up file", mig.Version, mig.Name)
+		}
+		bs, err := fs.ReadFile(m.source, mig.UpFile)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(bs)
+		mig.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// applied returns applied migrations ordered by version, creating the table if missing
+func (m *Migrator) applied(ctx context.Context, table string) ([]*appliedMigration, error) {
+	db := m.executer.db
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    version BIGINT NOT NULL,
+    name VARCHAR(191) NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    applied_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (version)
+)`, table)); err != nil {
+		return nil, errors.Wrap(err, "create migration table")
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s ORDER BY version", table))
+	if err != nil {
+		return nil, errors.Wrap(err, "select applied migrations")
+	}
+	defer rows.Close()
+	var applied []*appliedMigration
+	for rows.Next() {
+		var a appliedMigration
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&a.version, &a.name, &a.checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		a.appliedAt = appliedAt.Time
+		applied = append(applied, &a)
+	}
+	return applied, rows.Err()
+}
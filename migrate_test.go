@@ -0,0 +1,199 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+DROP TABLE IF EXISTS mysqlbatch.test_schema_migrations;
+DROP TABLE IF EXISTS mysqlbatch.migrate_users;
+DROP TABLE IF EXISTS mysqlbatch.migrate_groups;
+`), nil))
+
+	source := fstest.MapFS{
+		"0001_create_users.up.sql":    {Data: []byte("CREATE TABLE mysqlbatch.migrate_users (id INTEGER, PRIMARY KEY (id));")},
+		"0001_create_users.down.sql":  {Data: []byte("DROP TABLE mysqlbatch.migrate_users;")},
+		"0002_create_groups.up.sql":   {Data: []byte("CREATE TABLE mysqlbatch.{{ var(\"groups\", \"migrate_groups\") }} (id INTEGER, PRIMARY KEY (id));")},
+		"0002_create_groups.down.sql": {Data: []byte("DROP TABLE mysqlbatch.migrate_groups;")},
+		"0003_insert_users.sql":       {Data: []byte("INSERT INTO mysqlbatch.migrate_users VALUES (1);")},
+		"README.md":                   {Data: []byte("not a migration")},
+	}
+	m := mysqlbatch.NewMigrator(e, source)
+	m.SetTable("mysqlbatch.test_schema_migrations")
+	ctx := context.Background()
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	for _, status := range statuses {
+		require.False(t, status.Applied)
+	}
+
+	applied, err := m.Up(ctx, 2, nil)
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	require.EqualValues(t, 1, applied[0].Version)
+	require.Equal(t, "create_groups", applied[1].Name)
+
+	applied, err = m.Up(ctx, 0, nil)
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+	require.EqualValues(t, 3, applied[0].Version)
+
+	_, err = m.Down(ctx, 1, nil)
+	require.EqualError(t, err, "migration 3_insert_users has no down file")
+
+	source["0001_create_users.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE mysqlbatch.migrate_users (id BIGINT, PRIMARY KEY (id));")}
+	statuses, err = m.Status(ctx)
+	require.NoError(t, err)
+	require.True(t, statuses[0].Modified)
+	require.True(t, statuses[0].Applied)
+	_, err = m.Up(ctx, 0, nil)
+	require.EqualError(t, err, "migration 1_create_users was modified after applied")
+
+	source["0001_create_users.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE mysqlbatch.migrate_users (id INTEGER, PRIMARY KEY (id));")}
+	delete(source, "0003_insert_users.sql")
+	statuses, err = m.Status(ctx)
+	require.NoError(t, err)
+	require.True(t, statuses[2].Missing)
+	source["0003_insert_users.down.sql"] = &fstest.MapFile{Data: []byte("USE mysqlbatch; DELETE FROM migrate_users;")}
+	source["0003_insert_users.up.sql"] = &fstest.MapFile{Data: []byte("INSERT INTO mysqlbatch.migrate_users VALUES (1);")}
+
+	rolledBack, err := m.Down(ctx, 3, nil)
+	require.NoError(t, err)
+	require.Len(t, rolledBack, 3)
+	require.EqualValues(t, 3, rolledBack[0].Version)
+	require.EqualValues(t, 1, rolledBack[2].Version)
+	statuses, err = m.Status(ctx)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		require.False(t, status.Applied)
+	}
+}
+
+func TestMigrator__Concurrent(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	holder, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer holder.Close()
+	require.NoError(t, holder.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+DROP TABLE IF EXISTS mysqlbatch.test_concurrent_migrations;
+DROP TABLE IF EXISTS mysqlbatch.migrate_counts;
+CREATE TABLE mysqlbatch.migrate_counts (id INTEGER auto_increment, PRIMARY KEY (id));
+`), nil))
+	source := fstest.MapFS{
+		"0001_insert_count.sql": {Data: []byte("INSERT INTO mysqlbatch.migrate_counts VALUES ();")},
+		"0002_insert_count.sql": {Data: []byte("INSERT INTO mysqlbatch.migrate_counts VALUES ();")},
+	}
+	newMigrator := func() *mysqlbatch.Migrator {
+		e, err := mysqlbatch.New(context.Background(), conf)
+		require.NoError(t, err)
+		t.Cleanup(func() { e.Close() })
+		m := mysqlbatch.NewMigrator(e, source)
+		m.SetTable("mysqlbatch.test_concurrent_migrations")
+		return m
+	}
+	ctx := context.Background()
+
+	// the lock held by another session
+	require.NoError(t, holder.Execute(strings.NewReader("SELECT GET_LOCK('mysqlbatch-migrate:mysqlbatch.test_concurrent_migrations', 0);"), nil))
+	m := newMigrator()
+	m.SetLockTimeout(time.Second)
+	_, err = m.Up(ctx, 0, nil)
+	require.ErrorIs(t, err, mysqlbatch.ErrLockNotAcquired)
+	require.NoError(t, holder.Execute(strings.NewReader("SELECT RELEASE_LOCK('mysqlbatch-migrate:mysqlbatch.test_concurrent_migrations');"), nil))
+
+	var wg sync.WaitGroup
+	results := make([][]*mysqlbatch.Migration, 3)
+	errs := make([]error, 3)
+	for i := range results {
+		m := newMigrator()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = m.Up(ctx, 0, nil)
+		}()
+	}
+	wg.Wait()
+	var applied int
+	for i := range results {
+		require.NoError(t, errs[i])
+		applied += len(results[i])
+	}
+	require.Equal(t, 2, applied)
+
+	var rows [][]string
+	holder.SetSelectHook(func(query string, columns []string, r [][]string) {
+		rows = r
+	})
+	require.NoError(t, holder.Execute(strings.NewReader("SELECT COUNT(*) FROM mysqlbatch.migrate_counts;"), nil))
+	require.Equal(t, [][]string{{"2"}}, rows)
+}
+
+func TestMigrator__WithUse(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	setup, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer setup.Close()
+	require.NoError(t, setup.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+CREATE DATABASE IF NOT EXISTS mysqlbatch_other;
+DROP TABLE IF EXISTS mysqlbatch.use_schema_migrations;
+DROP TABLE IF EXISTS mysqlbatch_other.use_schema_migrations;
+DROP TABLE IF EXISTS mysqlbatch_other.migrate_other;
+`), nil))
+
+	conf.Database = "mysqlbatch"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	source := fstest.MapFS{
+		"0001_create_other.up.sql":   {Data: []byte("USE mysqlbatch_other;\nCREATE TABLE migrate_other (id INTEGER, PRIMARY KEY (id));")},
+		"0001_create_other.down.sql": {Data: []byte("USE mysqlbatch_other;\nDROP TABLE migrate_other;")},
+	}
+	m := mysqlbatch.NewMigrator(e, source)
+	m.SetTable("use_schema_migrations")
+	ctx := context.Background()
+
+	applied, err := m.Up(ctx, 0, nil)
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+	applied, err = m.Up(ctx, 0, nil)
+	require.NoError(t, err)
+	require.Empty(t, applied, "the migration is recorded in the database before USE")
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	require.True(t, statuses[0].Applied)
+
+	var count int
+	require.NoError(t, e.DB().QueryRow("SELECT COUNT(*) FROM mysqlbatch.use_schema_migrations").Scan(&count))
+	require.Equal(t, 1, count)
+
+	rolledBack, err := m.Down(ctx, 1, nil)
+	require.NoError(t, err)
+	require.Len(t, rolledBack, 1)
+	require.NoError(t, e.DB().QueryRow("SELECT COUNT(*) FROM mysqlbatch.use_schema_migrations").Scan(&count))
+	require.Equal(t, 0, count)
+}
@@ -0,0 +1,153 @@
+package mysqlbatch
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// OutputFormat is the file format of result sets
+type OutputFormat string
+
+const (
+	OutputFormatCSV     OutputFormat = "csv"
+	OutputFormatJSONL   OutputFormat = "jsonl"
+	OutputFormatParquet OutputFormat = "parquet"
+)
+
+// ParseOutputFormat returns the output format of csv, jsonl or parquet
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch f := OutputFormat(strings.ToLower(s)); f {
+	case OutputFormatCSV, OutputFormatJSONL, OutputFormatParquet:
+		return f, nil
+	}
+	return "", errors.Errorf("unknown output format `%s`, must be csv, jsonl or parquet", s)
+}
+
+// WriteResultSet writes all rows of the result set to w in the format, and returns the number of rows.
+// CSV has the header line, JSONL has an object per row keyed by the column names, and all columns of Parquet are optional strings.
+func WriteResultSet(w io.Writer, rs *ResultSetReader, format OutputFormat) (int64, error) {
+	switch format {
+	case OutputFormatCSV:
+		return writeCSV(w, rs)
+	case OutputFormatJSONL:
+		return writeJSONL(w, rs)
+	case OutputFormatParquet:
+		return writeParquet(w, rs)
+	}
+	return 0, errors.Errorf("unknown output format `%s`", format)
+}
+
+func writeCSV(w io.Writer, rs *ResultSetReader) (int64, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(rs.Columns); err != nil {
+		return 0, err
+	}
+	var n int64
+	for rs.Next() {
+		if err := cw.Write(rs.Row()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rs.Err(); err != nil {
+		return n, err
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+func writeJSONL(w io.Writer, rs *ResultSetReader) (int64, error) {
+	// encode keys once, and keep the order of columns
+	keys := make([][]byte, len(rs.Columns))
+	for i, c := range rs.Columns {
+		bs, err := json.Marshal(c)
+		if err != nil {
+			return 0, err
+		}
+		keys[i] = bs
+	}
+	var n int64
+	var buf bytes.Buffer
+	for rs.Next() {
+		buf.Reset()
+		buf.WriteByte('{')
+		for i, v := range rs.Values() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(keys[i])
+			buf.WriteByte(':')
+			bs, err := json.Marshal(v)
+			if err != nil {
+				return n, err
+			}
+			buf.Write(bs)
+		}
+		buf.WriteString("}\n")
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rs.Err()
+}
+
+// parquetRowGroupSize bounds the rows buffered by the parquet writer
+const parquetRowGroupSize = 16 * 1024 * 1024
+
+func writeParquet(w io.Writer, rs *ResultSetReader) (int64, error) {
+	md := make([]string, len(rs.Columns))
+	for i, name := range parquetColumnNames(rs.Columns) {
+		md[i] = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL", name)
+	}
+	pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+	if err != nil {
+		return 0, errors.Wrap(err, "create parquet writer")
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	var n int64
+	for rs.Next() {
+		if err := pw.WriteString(rs.Values()); err != nil {
+			return n, errors.Wrap(err, "write parquet")
+		}
+		n++
+	}
+	if err := rs.Err(); err != nil {
+		return n, err
+	}
+	if err := pw.WriteStop(); err != nil {
+		return n, errors.Wrap(err, "write parquet")
+	}
+	return n, nil
+}
+
+// parquetColumnNames returns the column names usable in the schema definition, which is separated by commas and equal signs.
+// Duplicated names like `id` of joined tables are suffixed by the position.
+func parquetColumnNames(columns []string) []string {
+	names := make([]string, len(columns))
+	seen := make(map[string]bool, len(columns))
+	for i, column := range columns {
+		name := strings.Map(func(r rune) rune {
+			switch r {
+			case ',', '=', ' ', '\t', '\n':
+				return '_'
+			}
+			return r
+		}, column)
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		} else if seen[strings.ToLower(name)] {
+			name = fmt.Sprintf("%s_%d", name, i+1)
+		}
+		seen[strings.ToLower(name)] = true
+		names[i] = name
+	}
+	return names
+}
@@ -0,0 +1,132 @@
+package mysqlbatch_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+const testOutputQuery = `SELECT 1 AS id, 'a,b' AS name, NULL AS note UNION ALL SELECT 2, 'c"d', 'x';`
+
+func TestWriteResultSet(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	outputs := make(map[mysqlbatch.OutputFormat][]byte)
+	for _, format := range []mysqlbatch.OutputFormat{mysqlbatch.OutputFormatCSV, mysqlbatch.OutputFormatJSONL, mysqlbatch.OutputFormatParquet} {
+		var buf bytes.Buffer
+		e.SetResultSetReaderHook(func(rs *mysqlbatch.ResultSetReader) error {
+			n, err := mysqlbatch.WriteResultSet(&buf, rs, format)
+			require.EqualValues(t, 2, n)
+			return err
+		})
+		require.NoError(t, e.Execute(strings.NewReader(testOutputQuery), nil))
+		outputs[format] = buf.Bytes()
+	}
+	require.Equal(t, "id,name,note\n1,\"a,b\",\n2,\"c\"\"d\",x\n", string(outputs[mysqlbatch.OutputFormatCSV]))
+	require.Equal(t, `{"id":"1","name":"a,b","note":null}
+{"id":"2","name":"c\"d","note":"x"}
+`, string(outputs[mysqlbatch.OutputFormatJSONL]))
+
+	type row struct {
+		ID   *string `parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+		Name *string `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+		Note *string `parquet:"name=note, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	}
+	pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(outputs[mysqlbatch.OutputFormatParquet]), new(row), 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+	require.EqualValues(t, 2, pr.GetNumRows())
+	rows := make([]row, 2)
+	require.NoError(t, pr.Read(&rows))
+	require.Equal(t, []row{
+		{ID: aws.String("1"), Name: aws.String("a,b")},
+		{ID: aws.String("2"), Name: aws.String(`c"d`), Note: aws.String("x")},
+	}, rows)
+}
+
+func TestS3ResultWriter(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	objects := make(map[string][]byte)
+	w := &mysqlbatch.S3ResultWriter{
+		LoadAWSDefaultConfigOptions: []func(*config.LoadOptions) error{
+			config.WithRegion("ap-northeast-1"),
+			config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+			config.WithAPIOptions([]func(stack *middleware.Stack) error{
+				func(stack *middleware.Stack) error {
+					return stack.Initialize.Add(
+						middleware.InitializeMiddlewareFunc("test",
+							func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+								params, ok := in.Parameters.(*s3.PutObjectInput)
+								if !ok {
+									return next.HandleInitialize(ctx, in)
+								}
+								bs, err := io.ReadAll(params.Body)
+								if err != nil {
+									return middleware.InitializeOutput{}, middleware.Metadata{}, err
+								}
+								objects["s3://"+aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)] = bs
+								return middleware.InitializeOutput{Result: &s3.PutObjectOutput{}}, middleware.Metadata{}, nil
+							},
+						),
+						middleware.Before,
+					)
+				},
+			}),
+		},
+		Prefix: "s3://example-bucket/results/request-id/",
+		Format: mysqlbatch.OutputFormatCSV,
+		Gzip:   true,
+	}
+	var results []*mysqlbatch.S3ResultObject
+	e.SetResultSetReaderHook(func(rs *mysqlbatch.ResultSetReader) error {
+		obj, err := w.Write(context.Background(), rs)
+		if err != nil {
+			return err
+		}
+		results = append(results, obj)
+		return nil
+	})
+	require.NoError(t, e.Execute(strings.NewReader(testOutputQuery+"\nSELECT 'done' AS status;"), nil))
+	require.Equal(t, []*mysqlbatch.S3ResultObject{
+		{
+			URI:     "s3://example-bucket/results/request-id/0001.csv.gz",
+			Query:   strings.TrimSuffix(testOutputQuery, ";"),
+			Columns: []string{"id", "name", "note"},
+			Rows:    2,
+		},
+		{
+			URI:     "s3://example-bucket/results/request-id/0002.csv.gz",
+			Query:   "SELECT 'done' AS status",
+			Columns: []string{"status"},
+			Rows:    1,
+		},
+	}, results)
+	gz, err := gzip.NewReader(bytes.NewReader(objects["s3://example-bucket/results/request-id/0002.csv.gz"]))
+	require.NoError(t, err)
+	bs, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	require.Equal(t, "status\ndone\n", string(bs))
+}
@@ -0,0 +1,335 @@
+package mysqlbatch
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Policy restricts statements executed by Executer. All statements are checked before execution.
+// Table patterns are matched by path.Match case-insensitively, like `db.table`, `db.*` or `users` (any database).
+// Unqualified table names are resolved by USE statements in the batch. If the database is unknown, deny patterns match by the table name,
+// and allow patterns match only if the database part is `*`.
+type Policy struct {
+	// AllowStatements is the statement types allowed, the first keyword like SELECT or INSERT. Empty means all.
+	AllowStatements []string `yaml:"allow_statements,omitempty" json:"allow_statements,omitempty"`
+	// DenyStatements is the statement types denied
+	DenyStatements []string `yaml:"deny_statements,omitempty" json:"deny_statements,omitempty"`
+	// AllowTables is the table patterns allowed to be referred. Empty means all.
+	AllowTables []string `yaml:"allow_tables,omitempty" json:"allow_tables,omitempty"`
+	// DenyTables is the table patterns denied to be referred
+	DenyTables []string `yaml:"deny_tables,omitempty" json:"deny_tables,omitempty"`
+}
+
+// Validate returns an error if the table patterns are malformed
+func (p *Policy) Validate() error {
+	for _, pattern := range append(append([]string{}, p.AllowTables...), p.DenyTables...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("invalid table pattern `%s`", pattern)
+		}
+	}
+	return nil
+}
+
+// SetPolicy set Policy restricting statements. If p is nil, all statements are allowed.
+func (e *Executer) SetPolicy(p *Policy) {
+	e.policy = p
+}
+
+// tableRef is a table referred by the statement, db is empty if unknown
+type tableRef struct {
+	db    string
+	table string
+}
+
+func (ref tableRef) String() string {
+	if ref.db == "" {
+		return ref.table
+	}
+	return ref.db + "." + ref.table
+}
+
+// violation returns why the statement violates the policy, or empty if allowed
+func (p *Policy) violation(tokens []token, currentDB string) string {
+	verb := statementVerb(tokens)
+	if len(p.AllowStatements) > 0 && !containsFold(p.AllowStatements, verb) {
+		return fmt.Sprintf("statement type %s is not allowed by policy", verb)
+	}
+	if containsFold(p.DenyStatements, verb) {
+		return fmt.Sprintf("statement type %s is denied by policy", verb)
+	}
+	refs, resolved := tableReferences(tokens, currentDB)
+	if !resolved && (len(p.AllowTables) > 0 || len(p.DenyTables) > 0) {
+		return "tables can not be resolved for the table patterns of policy"
+	}
+	for _, ref := range refs {
+		if msg := p.tableViolation(ref); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// CheckTable returns an error if the table like `db.table` or `table` is not allowed by the table patterns.
+// An unqualified table is resolved by database, unknown if empty.
+func (p *Policy) CheckTable(table string, database string) error {
+	ref := tableRef{db: database, table: strings.Trim(table, "`")}
+	if db, name, ok := strings.Cut(table, "."); ok {
+		ref = tableRef{db: strings.Trim(db, "`"), table: strings.Trim(name, "`")}
+	}
+	if msg := p.tableViolation(ref); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// tableViolation returns why the table violates the table patterns, or empty if allowed
+func (p *Policy) tableViolation(ref tableRef) string {
+	for _, pattern := range p.DenyTables {
+		if matchTable(pattern, ref, true) {
+			return fmt.Sprintf("table %s is denied by policy", ref)
+		}
+	}
+	if len(p.AllowTables) == 0 {
+		return ""
+	}
+	for _, pattern := range p.AllowTables {
+		if matchTable(pattern, ref, false) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("table %s is not allowed by policy", ref)
+}
+
+// matchTable matches the pattern to ref. If the database of ref is unknown, it matches when unknownMatches is true, or the database part of the pattern is `*`.
+func matchTable(pattern string, ref tableRef, unknownMatches bool) bool {
+	pattern = strings.ToLower(pattern)
+	dbPattern, tablePattern, ok := strings.Cut(pattern, ".")
+	if !ok {
+		dbPattern, tablePattern = "*", pattern
+	}
+	if matched, _ := path.Match(tablePattern, strings.ToLower(ref.table)); !matched {
+		return false
+	}
+	if ref.db == "" {
+		return unknownMatches || dbPattern == "*"
+	}
+	matched, _ := path.Match(dbPattern, strings.ToLower(ref.db))
+	return matched
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableListKeywords are followed by a table name, or comma separated table names
+var tableListKeywords = map[string]bool{
+	"FROM": true, "JOIN": true, "STRAIGHT_JOIN": true, "INTO": true, "UPDATE": true, "TABLE": true, "TABLES": true,
+}
+
+// singleTableKeywords are followed by a table name, not a list
+var singleTableKeywords = map[string]bool{
+	"JOIN": true, "STRAIGHT_JOIN": true, "INTO": true, "TO": true, "LIKE": true, "RENAME": true,
+}
+
+// aliasTerminators are keywords which can follow a table name, not an alias
+var aliasTerminators = map[string]bool{
+	"WHERE": true, "SET": true, "ON": true, "USING": true, "JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true,
+	"CROSS": true, "NATURAL": true, "STRAIGHT_JOIN": true, "GROUP": true, "ORDER": true, "LIMIT": true, "HAVING": true,
+	"UNION": true, "VALUES": true, "VALUE": true, "SELECT": true, "PARTITION": true, "FOR": true, "LOCK": true,
+	"WINDOW": true, "INTO": true, "FROM": true, "USE": true, "FORCE": true, "IGNORE": true, "LIKE": true,
+	"READ": true, "WRITE": true, "TABLE": true, "WITH": true, "EXCEPT": true, "INTERSECT": true, "TO": true,
+}
+
+// selectModifiers can precede STRAIGHT_JOIN as a modifier of SELECT, not a join
+var selectModifiers = map[string]bool{
+	"SELECT": true, "ALL": true, "DISTINCT": true, "DISTINCTROW": true, "HIGH_PRIORITY": true,
+}
+
+// isTableKeyword returns true if the keyword at i is followed by table names in the statement of verb
+func isTableKeyword(tokens []token, i int, verb string) bool {
+	kw := tokens[i].keyword()
+	switch {
+	case kw == "UPDATE" && i > 0 && (tokens[i-1].keyword() == "FOR" || tokens[i-1].keyword() == "KEY" || tokens[i-1].keyword() == "ON"):
+		// SELECT ... FOR UPDATE, INSERT ... ON DUPLICATE KEY UPDATE, ON UPDATE CURRENT_TIMESTAMP
+		return false
+	case kw == "STRAIGHT_JOIN" && i > 0 && selectModifiers[tokens[i-1].keyword()]:
+		// SELECT STRAIGHT_JOIN ...
+		return false
+	case kw == "TABLES" && verb == "SHOW":
+		return false
+	case kw == "TO":
+		// RENAME TABLE a TO b, c TO d
+		return verb == "RENAME" && len(tokens) > 1 && tokens[1].keyword() == "TABLE"
+	case kw == "RENAME":
+		// ALTER TABLE a RENAME [TO|AS] b, except RENAME COLUMN, INDEX and KEY
+		if verb != "ALTER" || i+1 >= len(tokens) {
+			return false
+		}
+		switch tokens[i+1].keyword() {
+		case "COLUMN", "INDEX", "KEY":
+			return false
+		}
+		return true
+	case kw == "LIKE":
+		// CREATE TABLE a LIKE b
+		return verb == "CREATE"
+	case kw == "USING":
+		// DELETE FROM a USING a, b, except JOIN ... USING (col)
+		return verb == "DELETE" && i+1 < len(tokens) && tokens[i+1].text != "("
+	case i == 0:
+		// INSERT and REPLACE without INTO
+		switch verb {
+		case "TRUNCATE", "DESCRIBE", "DESC", "INSERT", "REPLACE", "HANDLER":
+			return true
+		}
+	}
+	return tableListKeywords[kw]
+}
+
+// tableReferences returns tables referred by the statement, except common table expressions.
+// resolved is false if a table keyword is followed by something other than table names, e.g. a string.
+func tableReferences(tokens []token, currentDB string) (refs []tableRef, resolved bool) {
+	ctes := make(map[string]bool)
+	if len(tokens) > 0 && tokens[0].keyword() == "WITH" {
+		for i := 0; i+2 < len(tokens); i++ {
+			if tokens[i+1].keyword() == "AS" && tokens[i+2].text == "(" {
+				ctes[strings.ToLower(unquoteIdentifier(tokens[i].text))] = true
+			}
+		}
+	}
+	verb := statementVerb(tokens)
+	// inExpression is true for tokens in parentheses other than subqueries and table lists, like EXTRACT(YEAR FROM col)
+	inExpression := make([]bool, len(tokens))
+	var parens []bool
+	for i, t := range tokens {
+		if len(parens) > 0 {
+			inExpression[i] = parens[len(parens)-1]
+		}
+		switch t.text {
+		case "(":
+			isSubquery := i+1 < len(tokens) && (tokens[i+1].keyword() == "SELECT" || tokens[i+1].keyword() == "WITH" || tokens[i+1].text == "(")
+			// FROM (a, b), JOIN (a JOIN b), CREATE TABLE a (LIKE b)
+			isTableList := i > 0 && !inExpression[i] && (tableListKeywords[tokens[i-1].keyword()] ||
+				(verb == "CREATE" && i+1 < len(tokens) && tokens[i+1].keyword() == "LIKE") ||
+				(tokens[i-1].text == "(" && len(parens) > 0 && !parens[len(parens)-1]))
+			parens = append(parens, !isSubquery && !isTableList)
+		case ")":
+			if len(parens) > 0 {
+				parens = parens[:len(parens)-1]
+			}
+		}
+	}
+	resolved = true
+	for i := 0; i < len(tokens); i++ {
+		if inExpression[i] || !isTableKeyword(tokens, i, verb) {
+			continue
+		}
+		kw := tokens[i].keyword()
+		single := singleTableKeywords[kw] || (i == 0 && !tableListKeywords[kw])
+		j := i + 1
+		for {
+			// skip modifiers like IF NOT EXISTS, LOW_PRIORITY and IGNORE, and the opening parentheses of table lists
+			for j < len(tokens) {
+				switch tokens[j].keyword() {
+				case "IF", "NOT", "EXISTS", "LOW_PRIORITY", "HIGH_PRIORITY", "DELAYED", "IGNORE", "QUICK", "TEMPORARY", "ONLY":
+					j++
+					continue
+				case "TO", "AS":
+					if kw == "RENAME" {
+						j++
+						continue
+					}
+				}
+				if tokens[j].text == "(" && !inExpression[j] && j+1 < len(tokens) && tokens[j+1].keyword() != "SELECT" && tokens[j+1].keyword() != "WITH" {
+					j++
+					continue
+				}
+				break
+			}
+			if j >= len(tokens) {
+				break
+			}
+			if tokens[j].kind == tokenSymbol || (tokens[j].kind == tokenString && !isDoubleQuoted(tokens[j].text)) {
+				// a subquery is checked by its own keywords
+				if tokens[j].text != "(" {
+					resolved = false
+				}
+				break
+			}
+			if kw := tokens[j].keyword(); kw == "OUTFILE" || kw == "DUMPFILE" || kw == "DUAL" || aliasTerminators[kw] {
+				break
+			}
+			name, next := qualifiedName(tokens, j)
+			j = next
+			if strings.HasPrefix(name, "@") {
+				// SELECT ... INTO @var, but PREPARE s FROM @var is unknown
+				if kw != "INTO" {
+					resolved = false
+				}
+				break
+			}
+			ref := tableRef{table: name, db: currentDB}
+			if db, table, ok := strings.Cut(name, "."); ok {
+				ref = tableRef{db: db, table: table}
+			} else if ctes[strings.ToLower(name)] {
+				ref.table = ""
+			}
+			if ref.table != "" {
+				refs = append(refs, ref)
+			}
+			if single {
+				break
+			}
+			// skip the alias
+			if j < len(tokens) && tokens[j].keyword() == "AS" {
+				j++
+			}
+			if j < len(tokens) && tokens[j].kind != tokenSymbol && !aliasTerminators[tokens[j].keyword()] {
+				j++
+			}
+			if j >= len(tokens) || tokens[j].text != "," {
+				break
+			}
+			j++
+		}
+	}
+	return refs, resolved
+}
+
+// qualifiedName joins the tokens of the name like `db`.`table`, and returns the unquoted name and the next index.
+// Double-quoted names are identifiers in ANSI_QUOTES mode.
+func qualifiedName(tokens []token, i int) (string, int) {
+	name := unquoteIdentifier(tokens[i].text)
+	for i+1 < len(tokens) && tokens[i+1].kind != tokenSymbol && (tokens[i+1].kind != tokenString || isDoubleQuoted(tokens[i+1].text)) &&
+		(strings.HasSuffix(tokens[i].text, ".") || strings.HasPrefix(tokens[i+1].text, ".")) {
+		i++
+		if strings.HasPrefix(tokens[i].text, ".") && len(tokens[i].text) > 1 {
+			name += "." + unquoteIdentifier(tokens[i].text[1:])
+			continue
+		}
+		name += unquoteIdentifier(tokens[i].text)
+	}
+	return name, i + 1
+}
+
+func isDoubleQuoted(s string) bool {
+	return len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"'
+}
+
+func unquoteIdentifier(s string) string {
+	if len(s) >= 2 && s[0] == '`' && s[len(s)-1] == '`' {
+		return strings.ReplaceAll(s[1:len(s)-1], "``", "`")
+	}
+	if isDoubleQuoted(s) {
+		return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuterExecute__WithPolicy(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	policy := &mysqlbatch.Policy{
+		AllowStatements: []string{"select", "insert", "use", "with"},
+		AllowTables:     []string{"report.*", "*.events"},
+		DenyTables:      []string{"report.secrets"},
+	}
+	require.NoError(t, policy.Validate())
+	e.SetPolicy(policy)
+
+	cases := []struct {
+		query  string
+		reason string
+	}{
+		{query: "SELECT * FROM report.daily"},
+		{query: "SELECT * FROM `report`.`daily` d JOIN events e ON d.id = e.id"},
+		{query: "SELECT EXTRACT(YEAR FROM created_at) FROM report.daily"},
+		{query: "WITH t AS (SELECT * FROM report.daily) SELECT * FROM t"},
+		{query: "USE report;\nSELECT * FROM daily, monthly m"},
+		{query: "INSERT INTO report.daily(id) SELECT id FROM events"},
+		{query: "SELECT 1 FROM dual"},
+		{query: "DELETE FROM report.daily", reason: "statement type DELETE is not allowed by policy"},
+		{query: "SELECT * FROM app.users", reason: "table app.users is not allowed by policy"},
+		{query: "SELECT * FROM users", reason: "table users is not allowed by policy"},
+		{query: "SELECT * FROM report.daily WHERE id IN (SELECT id FROM app.users)", reason: "table app.users is not allowed by policy"},
+		{query: "SELECT * FROM report.daily, app.users", reason: "table app.users is not allowed by policy"},
+		{query: "SELECT * FROM report.SECRETS", reason: "table report.SECRETS is denied by policy"},
+		{query: "USE report;\nSELECT * FROM secrets", reason: "table report.secrets is denied by policy"},
+		{query: "SELECT * FROM secrets", reason: "table secrets is denied by policy"},
+		{query: "SELECT * FROM 'report.daily'", reason: "tables can not be resolved for the table patterns of policy"},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			err := e.Execute(strings.NewReader(c.query+";"), nil)
+			var unsafeErr *mysqlbatch.UnsafeStatementError
+			if c.reason == "" {
+				require.False(t, errors.As(err, &unsafeErr), "error: %v", err)
+				return
+			}
+			require.ErrorAs(t, err, &unsafeErr)
+			require.Equal(t, c.reason, unsafeErr.Statements[0].Reason)
+		})
+	}
+}
+
+func TestExecuterExecute__WithPolicyDenyTables(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	e.SetPolicy(&mysqlbatch.Policy{DenyTables: []string{"app.*", "secret"}})
+
+	queries := []string{
+		"INSERT app.users (id) VALUES (1)",
+		"INSERT LOW_PRIORITY IGNORE INTO app.users SET id = 1",
+		"REPLACE app.users VALUES (1)",
+		"RENAME TABLE report.x TO app.users",
+		"RENAME TABLE report.x TO report.y, report.z TO app.users",
+		"ALTER TABLE report.x RENAME TO app.users",
+		"CREATE TABLE report.z LIKE app.users",
+		"CREATE TABLE report.z (LIKE app.users)",
+		"SELECT * FROM (report.a, app.users)",
+		"SELECT * FROM (report.a JOIN app.users ON a.id = users.id)",
+		"SELECT * FROM report.a STRAIGHT_JOIN app.users",
+		"SELECT * FROM report . a, app . users",
+		`SELECT * FROM "app"."users"`,
+		"HANDLER app.users OPEN",
+		"DELETE FROM report.a USING report.a, app.users WHERE a.id = users.id",
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			err := e.Execute(strings.NewReader(query+";"), nil)
+			var unsafeErr *mysqlbatch.UnsafeStatementError
+			require.ErrorAs(t, err, &unsafeErr)
+			require.Equal(t, "table app.users is denied by policy", unsafeErr.Statements[0].Reason)
+		})
+	}
+
+	cases := []struct {
+		query  string
+		reason string
+	}{
+		{query: "PREPARE s FROM 'DELETE FROM secret'", reason: "tables can not be resolved for the table patterns of policy"},
+		{query: "PREPARE s FROM @query", reason: "tables can not be resolved for the table patterns of policy"},
+		{query: "HANDLER secret OPEN", reason: "table secret is denied by policy"},
+		{query: "DELETE FROM report.t1 USING report.t1, secret", reason: "table secret is denied by policy"},
+		{query: "SELECT 1 INTO @id"},
+		{query: "DELETE a FROM report.a a JOIN report.b b USING (id)"},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			err := e.Execute(strings.NewReader(c.query+";"), nil)
+			var unsafeErr *mysqlbatch.UnsafeStatementError
+			if c.reason == "" {
+				require.False(t, errors.As(err, &unsafeErr), "unexpected policy error: %v", err)
+				return
+			}
+			require.ErrorAs(t, err, &unsafeErr)
+			require.Equal(t, c.reason, unsafeErr.Statements[0].Reason)
+		})
+	}
+}
+
+func TestPolicyCheckTable(t *testing.T) {
+	policy := &mysqlbatch.Policy{
+		AllowTables: []string{"app.*_history", "*.checkpoints"},
+		DenyTables:  []string{"app.users"},
+	}
+	cases := []struct {
+		table    string
+		database string
+		reason   string
+	}{
+		{table: "app.batch_history"},
+		{table: "batch_history", database: "app"},
+		{table: "`app`.`batch_history`"},
+		{table: "checkpoints"},
+		{table: "batch_history", reason: "table batch_history is not allowed by policy"},
+		{table: "users", database: "app", reason: "table app.users is denied by policy"},
+		{table: "app.users", reason: "table app.users is denied by policy"},
+		{table: "other.batch_history", reason: "table other.batch_history is not allowed by policy"},
+	}
+	for _, c := range cases {
+		t.Run(c.table, func(t *testing.T) {
+			err := policy.CheckTable(c.table, c.database)
+			if c.reason == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, c.reason)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package mysqlbatch
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SetReadOnly enables read-only mode.
+// All statements of the batch are checked before execution, and the batch is rejected if a statement other than USE or select returning rows (classified by ClassifyStatement) is found.
+// Each connection executes the statements in START TRANSACTION READ ONLY, so the server also rejects writes.
+func (e *Executer) SetReadOnly(readOnly bool) {
+	e.readOnly = readOnly
+}
+
+// isReadOnlyStatement returns true if the query is allowed in read-only mode
+func isReadOnlyStatement(query string) bool {
+	class := ClassifyStatement(query)
+	return class.Verb == "USE" || (class.Kind == StatementKindSelect && class.ReturnsRows)
+}
+
+// startReadOnlyTransaction starts the read only transaction on conn, and returns the function ending it
+func (e *Executer) startReadOnlyTransaction(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if !e.readOnly {
+		return func() {}, nil
+	}
+	if _, err := conn.ExecContext(ctx, "START TRANSACTION READ ONLY"); err != nil {
+		return nil, errors.Wrap(err, "start read only transaction")
+	}
+	return func() {
+		// end even if ctx is canceled, because the connection is returned to the pool
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := conn.ExecContext(ctx, "ROLLBACK"); err != nil {
+			log.Printf("end read only transaction failed: %s", err)
+		}
+	}, nil
+}
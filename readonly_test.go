@@ -0,0 +1,46 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuterExecute__WithReadOnly(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(testChunkSetupSQL), nil))
+
+	var rows [][]string
+	e.SetSelectHook(func(_ string, _ []string, r [][]string) {
+		rows = r
+	})
+	e.SetReadOnly(true)
+	err = e.Execute(strings.NewReader(`
+USE mysqlbatch;
+SELECT COUNT(*) FROM chunk_events;
+DELETE FROM chunk_events WHERE id = 1;
+SET SESSION transaction_read_only = 0;
+`), nil)
+	var unsafeErr *mysqlbatch.UnsafeStatementError
+	require.ErrorAs(t, err, &unsafeErr)
+	require.Equal(t, []*mysqlbatch.UnsafeStatement{
+		{Query: "DELETE FROM chunk_events WHERE id = 1", Reason: "is not allowed in read-only mode"},
+		{Query: "SET SESSION transaction_read_only = 0", Reason: "is not allowed in read-only mode"},
+	}, unsafeErr.Statements)
+	require.Nil(t, rows, "no statement is executed if rejected")
+
+	require.NoError(t, e.Execute(strings.NewReader("USE mysqlbatch; SELECT COUNT(*) FROM chunk_events;"), nil))
+	require.Equal(t, [][]string{{"26"}}, rows)
+
+	e.SetReadOnly(false)
+	require.NoError(t, e.Execute(strings.NewReader("USE mysqlbatch; SELECT COUNT(*) FROM chunk_events;"), nil))
+	require.Equal(t, [][]string{{"26"}}, rows)
+}
@@ -0,0 +1,204 @@
+package mysqlbatch
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultRedactMask is the string that replaces redacted values.
+const DefaultRedactMask = "********"
+
+// Redactor masks sensitive values in rendered SQL, logs and errors.
+//
+// Values are masked when they are registered by AddValue, passed as a variable named by AddVarNames,
+// set to an environment variable named by AddEnvNames, or are SQL string literals matching AddLiteralPattern.
+type Redactor struct {
+	Mask string
+
+	mu              sync.RWMutex
+	values          map[string]struct{}
+	varNames        map[string]struct{}
+	envNames        map[string]struct{}
+	literalPatterns []*regexp.Regexp
+}
+
+// NewRedactor returns Redactor with DefaultRedactMask
+func NewRedactor() *Redactor {
+	return &Redactor{
+		Mask: DefaultRedactMask,
+	}
+}
+
+// AddValue registers value to be masked. Empty value is ignored.
+func (r *Redactor) AddValue(value string) {
+	if value == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.values == nil {
+		r.values = make(map[string]struct{})
+	}
+	r.values[value] = struct{}{}
+}
+
+// AddVarNames registers template variable names whose values are masked.
+func (r *Redactor) AddVarNames(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.varNames == nil {
+		r.varNames = make(map[string]struct{})
+	}
+	for _, name := range names {
+		r.varNames[name] = struct{}{}
+	}
+}
+
+// AddEnvNames registers environment variable names whose values are masked.
+func (r *Redactor) AddEnvNames(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.envNames == nil {
+		r.envNames = make(map[string]struct{})
+	}
+	for _, name := range names {
+		r.envNames[name] = struct{}{}
+	}
+}
+
+// AddLiteralPattern registers pattern for SQL string literals to be masked.
+// The pattern is matched against the content of the literal without quotes.
+func (r *Redactor) AddLiteralPattern(pattern *regexp.Regexp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.literalPatterns = append(r.literalPatterns, pattern)
+}
+
+// registerVars registers values of the variables and the environment variables to be masked.
+func (r *Redactor) registerVars(vars map[string]string) {
+	r.mu.RLock()
+	values := make([]string, 0, len(r.varNames)+len(r.envNames))
+	for name := range r.varNames {
+		if v, ok := vars[name]; ok {
+			values = append(values, v)
+		}
+	}
+	for name := range r.envNames {
+		if v, ok := os.LookupEnv(name); ok {
+			values = append(values, v)
+		}
+	}
+	r.mu.RUnlock()
+	for _, v := range values {
+		r.AddValue(v)
+	}
+}
+
+// Redact returns s with all sensitive values masked.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.literalPatterns) > 0 {
+		s = r.redactLiterals(s)
+	}
+	if len(r.values) == 0 {
+		return s
+	}
+	values := make([]string, 0, len(r.values))
+	for v := range r.values {
+		values = append(values, v)
+	}
+	// longer values first, so that a value containing another is masked entirely.
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	oldnew := make([]string, 0, len(values)*2)
+	for _, v := range values {
+		oldnew = append(oldnew, v, r.Mask)
+	}
+	return strings.NewReplacer(oldnew...).Replace(s)
+}
+
+func (r *Redactor) redactLiterals(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\'' && c != '"' {
+			buf.WriteByte(c)
+			continue
+		}
+		end := literalEnd(s, i)
+		if end < 0 {
+			buf.WriteString(s[i:])
+			break
+		}
+		content := s[i+1 : end]
+		buf.WriteByte(c)
+		if r.matchLiteral(content) {
+			buf.WriteString(r.Mask)
+		} else {
+			buf.WriteString(content)
+		}
+		buf.WriteByte(c)
+		i = end
+	}
+	return buf.String()
+}
+
+func (r *Redactor) matchLiteral(content string) bool {
+	for _, p := range r.literalPatterns {
+		if p.MatchString(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// literalEnd returns index of the closing quote of the literal starting at s[start], or -1.
+func literalEnd(s string, start int) int {
+	quote := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// RedactError returns err whose message is masked. errors.Is and errors.As still see the original err.
+func (r *Redactor) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := r.Redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
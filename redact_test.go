@@ -0,0 +1,84 @@
+package mysqlbatch_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactor(t *testing.T) {
+	r := mysqlbatch.NewRedactor()
+	require.Equal(t, "SELECT 'hoge'", r.Redact("SELECT 'hoge'"))
+	r.AddValue("")
+	r.AddValue("secret")
+	r.AddValue("secret_token")
+	require.Equal(t,
+		"SELECT '********', '********' FROM users",
+		r.Redact("SELECT 'secret_token', 'secret' FROM users"),
+	)
+
+	var nilRedactor *mysqlbatch.Redactor
+	require.Equal(t, "SELECT 'secret'", nilRedactor.Redact("SELECT 'secret'"))
+}
+
+func TestRedactorLiteralPattern(t *testing.T) {
+	r := mysqlbatch.NewRedactor()
+	r.AddLiteralPattern(regexp.MustCompile(`^[0-9a-f]{32}@example\.com$`))
+	require.Equal(t,
+		`UPDATE users SET name = '********' WHERE name = "********" AND memo = 'it''s 0123456789abcdef0123456789abcdef@example.com'`,
+		r.Redact(`UPDATE users SET name = '0123456789abcdef0123456789abcdef@example.com' WHERE name = "fedcba9876543210fedcba9876543210@example.com" AND memo = 'it''s 0123456789abcdef0123456789abcdef@example.com'`),
+	)
+	require.Equal(t, `SELECT 'unclosed`, r.Redact(`SELECT 'unclosed`))
+}
+
+func TestRedactorRedactError(t *testing.T) {
+	r := mysqlbatch.NewRedactor()
+	r.AddValue("secret")
+	require.NoError(t, r.RedactError(nil))
+
+	orig := errors.New("execute query `SELECT 'secret'` failed")
+	err := r.RedactError(fmt.Errorf("wrapped: %w", orig))
+	require.EqualError(t, err, "wrapped: execute query `SELECT '********'` failed")
+	require.ErrorIs(t, err, orig)
+
+	orig = errors.New("no sensitive values")
+	require.Same(t, orig, r.RedactError(orig))
+}
+
+func TestExecuterExecute__WithRedaction(t *testing.T) {
+	t.Setenv("API_TOKEN", "token_from_env")
+	var dumped bytes.Buffer
+	defer func(w io.Writer) {
+		mysqlbatch.DefaultSQLDumper = w
+	}(mysqlbatch.DefaultSQLDumper)
+	mysqlbatch.DefaultSQLDumper = &dumped
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	e.Redactor().AddVarNames("email")
+	e.Redactor().AddEnvNames("API_TOKEN")
+
+	var queries []string
+	e.SetSelectHook(func(query string, columns []string, rows [][]string) {
+		queries = append(queries, query)
+	})
+	err = e.Execute(strings.NewReader(`SELECT '{{ var("email", "") }}', '{{ env("API_TOKEN", "") }}'; SELECT * FROM {{ var("email", "") }};`), map[string]string{
+		"email": "hashed_email",
+	})
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "hashed_email")
+	require.Equal(t, []string{`SELECT '********', '********'`}, queries)
+	require.NotContains(t, dumped.String(), "hashed_email")
+	require.NotContains(t, dumped.String(), "token_from_env")
+}
@@ -0,0 +1,107 @@
+package mysqlbatch
+
+import (
+	"database/sql"
+)
+
+// ResultSetReader reads rows of a result set one by one, without buffering all rows in memory
+type ResultSetReader struct {
+	Query   string
+	Index   int
+	Columns []string
+	iter    *sql.Rows
+	values  []sql.NullString
+	dest    []interface{}
+	err     error
+}
+
+func newResultSetReader(query string, index int, columns []string, iter *sql.Rows) *ResultSetReader {
+	r := &ResultSetReader{
+		Query:   query,
+		Index:   index,
+		Columns: columns,
+		iter:    iter,
+		values:  make([]sql.NullString, len(columns)),
+		dest:    make([]interface{}, len(columns)),
+	}
+	for i := range r.values {
+		r.dest[i] = &r.values[i]
+	}
+	return r
+}
+
+// Next reads the next row, and returns false at the end of the result set or on error
+func (r *ResultSetReader) Next() bool {
+	if r.err != nil || !r.iter.Next() {
+		return false
+	}
+	if err := r.iter.Scan(r.dest...); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+// Row returns the current row as strings, NULL is an empty string
+func (r *ResultSetReader) Row() []string {
+	row := make([]string, len(r.values))
+	for i, v := range r.values {
+		row[i] = v.String
+	}
+	return row
+}
+
+// Values returns the current row, NULL is nil
+func (r *ResultSetReader) Values() []*string {
+	row := make([]*string, len(r.values))
+	for i, v := range r.values {
+		if v.Valid {
+			s := v.String
+			row[i] = &s
+		}
+	}
+	return row
+}
+
+// Err returns the error occurred while reading rows
+func (r *ResultSetReader) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.iter.Err()
+}
+
+// SetResultSetReaderHook set the hook reading each result set of select queries and CALL statements row by row.
+// It takes precedence over the select hook and the result set hook, and keeps memory bounded for large result sets.
+// The hook is called on the goroutine executing the statement, so it must be safe for concurrent use with parallelism.
+// Rows not read by the hook are discarded, and an error returned by the hook aborts the execution.
+func (e *Executer) SetResultSetReaderHook(hook func(rs *ResultSetReader) error) {
+	e.resultSetReaderHook = hook
+}
+
+// readResultSet passes the current result set of iter to the result set reader hook if set, otherwise emits all rows to the hook
+func (e *Executer) readResultSet(emit func(func()), query string, index int, columns []string, iter *sql.Rows) error {
+	rs := newResultSetReader(query, index, columns, iter)
+	if e.resultSetReaderHook == nil {
+		rows, err := rs.readAll()
+		if err != nil {
+			return err
+		}
+		e.emitResultSet(emit, query, index, columns, rows)
+		return nil
+	}
+	if err := e.resultSetReaderHook(rs); err != nil {
+		return err
+	}
+	for rs.Next() {
+	}
+	return rs.Err()
+}
+
+func (r *ResultSetReader) readAll() ([][]string, error) {
+	rows := make([][]string, 0)
+	for r.Next() {
+		rows = append(rows, r.Row())
+	}
+	return rows, r.Err()
+}
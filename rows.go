@@ -0,0 +1,108 @@
+package mysqlbatch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// AffectedRowsExceededError is returned when a statement affects more rows than the limit
+type AffectedRowsExceededError struct {
+	Query        string
+	RowsAffected int64
+	Limit        int64
+	// RolledBack is true if the statement was in a transaction, and it was rolled back
+	RolledBack bool
+}
+
+func (e *AffectedRowsExceededError) Error() string {
+	msg := fmt.Sprintf("query `%s` affected %d rows, exceeds the limit %d", e.Query, e.RowsAffected, e.Limit)
+	if e.RolledBack {
+		msg += ", rolled back"
+	}
+	return msg
+}
+
+// SetMaxAffectedRows set the maximum rows affected by each UPDATE or DELETE statement.
+// The limit of a statement can be set by the annotation comment `-- mysqlbatch:max-affected-rows limit=1000`.
+// If exceeded, the execution is aborted, and the transaction is rolled back if the statement was executed in a transaction started by BEGIN or START TRANSACTION.
+// n <= 0 means unlimited.
+func (e *Executer) SetMaxAffectedRows(n int64) {
+	e.maxAffectedRows = n
+}
+
+// affectedRowsLimit returns the limit of rows affected by the statement, 0 means unlimited
+func (e *Executer) affectedRowsLimit(stmt *statement) (int64, error) {
+	if a, ok := stmt.annotation("max-affected-rows"); ok {
+		for key, value := range a {
+			if key != "limit" {
+				return 0, errors.Errorf("unknown max-affected-rows parameter `%s`", key)
+			}
+			limit, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || limit <= 0 {
+				return 0, errors.Errorf("max-affected-rows limit must be positive integer, got `%s`", value)
+			}
+			return limit, nil
+		}
+		return 0, errors.New("max-affected-rows requires limit parameter")
+	}
+	if e.maxAffectedRows <= 0 {
+		return 0, nil
+	}
+	switch statementVerb(tokenize(stmt.query)) {
+	case "UPDATE", "DELETE":
+		return e.maxAffectedRows, nil
+	}
+	return 0, nil
+}
+
+// checkAffectedRows returns AffectedRowsExceededError if rowsAffected exceeds limit, rolling back the transaction on q
+func (e *Executer) checkAffectedRows(ctx context.Context, q queryer, redactedQuery string, rowsAffected int64, limit int64) error {
+	if limit <= 0 || rowsAffected <= limit {
+		return nil
+	}
+	exceeded := &AffectedRowsExceededError{
+		Query:        redactedQuery,
+		RowsAffected: rowsAffected,
+		Limit:        limit,
+	}
+	if e.current.inTransaction(q) {
+		if _, err := q.ExecContext(context.WithoutCancel(ctx), "ROLLBACK"); err != nil {
+			return fmt.Errorf("%w, but rollback failed: %s", exceeded, err)
+		}
+		e.current.trackTransaction(q, tokenize("ROLLBACK"))
+		exceeded.RolledBack = true
+	}
+	return exceeded
+}
+
+// trackTransaction updates the transaction state of q by the executed statement
+func (ex *execution) trackTransaction(q queryer, tokens []token) {
+	var inTx bool
+	switch statementVerb(tokens) {
+	case "BEGIN", "START":
+		inTx = true
+	case "COMMIT":
+	case "ROLLBACK":
+		if len(tokens) > 1 && tokens[1].keyword() == "TO" {
+			// ROLLBACK TO SAVEPOINT does not end the transaction
+			return
+		}
+	default:
+		return
+	}
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if ex.transactions == nil {
+		ex.transactions = make(map[queryer]bool)
+	}
+	ex.transactions[q] = inTx
+}
+
+func (ex *execution) inTransaction(q queryer) bool {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	return ex.transactions[q]
+}
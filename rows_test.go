@@ -0,0 +1,76 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuterExecute__WithMaxAffectedRows(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(testChunkSetupSQL), nil))
+
+	var rows [][]string
+	e.SetSelectHook(func(_ string, _ []string, r [][]string) {
+		rows = r
+	})
+	countEvents := func() string {
+		t.Helper()
+		require.NoError(t, e.Execute(strings.NewReader("SELECT COUNT(*) FROM mysqlbatch.chunk_events;"), nil))
+		return rows[0][0]
+	}
+
+	e.SetMaxAffectedRows(10)
+	err = e.Execute(strings.NewReader(`
+USE mysqlbatch;
+START TRANSACTION;
+DELETE FROM chunk_events WHERE created_at < '2023-01-01';
+COMMIT;
+`), nil)
+	var exceeded *mysqlbatch.AffectedRowsExceededError
+	require.ErrorAs(t, err, &exceeded)
+	require.Equal(t, &mysqlbatch.AffectedRowsExceededError{
+		Query:        "DELETE FROM chunk_events WHERE created_at < '2023-01-01'",
+		RowsAffected: 25,
+		Limit:        10,
+		RolledBack:   true,
+	}, exceeded)
+	require.Equal(t, "26", countEvents(), "the transaction is rolled back")
+
+	err = e.Execute(strings.NewReader(`
+USE mysqlbatch;
+-- mysqlbatch:chunk size=10
+DELETE FROM chunk_events WHERE created_at < '2023-01-01';
+`), nil)
+	require.ErrorAs(t, err, &exceeded)
+	require.Equal(t, int64(20), exceeded.RowsAffected, "checked after each chunk")
+	require.False(t, exceeded.RolledBack)
+	require.Equal(t, "6", countEvents())
+
+	err = e.Execute(strings.NewReader(`
+USE mysqlbatch;
+BEGIN;
+-- mysqlbatch:max-affected-rows limit=4
+DELETE FROM chunk_events WHERE created_at < '2023-01-01';
+COMMIT;
+`), nil)
+	require.ErrorAs(t, err, &exceeded)
+	require.Equal(t, int64(4), exceeded.Limit, "the annotation overrides the global limit")
+	require.True(t, exceeded.RolledBack)
+	require.Equal(t, "6", countEvents())
+
+	err = e.Execute(strings.NewReader(`
+USE mysqlbatch;
+-- mysqlbatch:max-affected-rows limit=0
+DELETE FROM chunk_events;
+`), nil)
+	require.EqualError(t, err, "query `DELETE FROM chunk_events`: max-affected-rows limit must be positive integer, got `0`")
+}
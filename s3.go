@@ -0,0 +1,277 @@
+package mysqlbatch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+)
+
+// S3Fetcher fetches SQL files from S3
+type S3Fetcher struct {
+	LoadAWSDefaultConfigOptions []func(*config.LoadOptions) error
+	// ClientOptions are applied to the S3 client, e.g. the endpoint of S3 compatible storage
+	ClientOptions []func(*s3.Options)
+	mu            sync.Mutex
+	s3Client      *s3.Client
+}
+
+// IsS3URI returns true if the path is s3://bucket/key
+func IsS3URI(path string) bool {
+	return strings.HasPrefix(path, "s3://")
+}
+
+// parseS3URI returns the bucket and the key of s3://bucket/key
+func parseS3URI(uri string) (string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "parse s3 uri `%s`", uri)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", errors.Errorf("invalid s3 uri `%s`, expected s3://bucket/key", uri)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+func (f *S3Fetcher) client(ctx context.Context) (*s3.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.s3Client == nil {
+		client, err := newS3Client(ctx, f.LoadAWSDefaultConfigOptions, f.ClientOptions)
+		if err != nil {
+			return nil, err
+		}
+		f.s3Client = client
+	}
+	return f.s3Client, nil
+}
+
+func newS3Client(ctx context.Context, loadOptions []func(*config.LoadOptions) error, clientOptions []func(*s3.Options)) (*s3.Client, error) {
+	awsConf, err := config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(awsConf, clientOptions...), nil
+}
+
+// Fetch returns the content of the object s3://bucket/key. The latest version is fetched if versionID is empty.
+func (f *S3Fetcher) Fetch(ctx context.Context, uri string, versionID string) ([]byte, error) {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		return nil, errors.Errorf("s3 uri `%s` is not an object", uri)
+	}
+	client, err := f.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	log.Printf("get s3 object `%s`", uri)
+	output, err := client.GetObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("get s3 object `%s`: %w", uri, err)
+	}
+	defer output.Body.Close()
+	bs, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read s3 object `%s`: %w", uri, err)
+	}
+	return bs, nil
+}
+
+// List returns URIs of `.sql` objects under the prefix s3://bucket/prefix in the order of keys
+func (f *S3Fetcher) List(ctx context.Context, prefixURI string) ([]string, error) {
+	bucket, prefix, err := parseS3URI(prefixURI)
+	if err != nil {
+		return nil, err
+	}
+	client, err := f.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list s3 objects `%s`: %w", prefixURI, err)
+		}
+		for _, obj := range output.Contents {
+			if key := aws.ToString(obj.Key); strings.HasSuffix(key, ".sql") {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	uris := make([]string, 0, len(keys))
+	for _, key := range keys {
+		uris = append(uris, "s3://"+bucket+"/"+key)
+	}
+	return uris, nil
+}
+
+// FetchGroups returns `.sql` objects under the prefix as statement groups named by the URI, in the order of keys
+func (f *S3Fetcher) FetchGroups(ctx context.Context, prefixURI string) ([]QueryGroup, error) {
+	uris, err := f.List(ctx, prefixURI)
+	if err != nil {
+		return nil, err
+	}
+	if len(uris) == 0 {
+		return nil, errors.Errorf("no sql objects found under `%s`", prefixURI)
+	}
+	groups := make([]QueryGroup, 0, len(uris))
+	for _, uri := range uris {
+		bs, err := f.Fetch(ctx, uri, "")
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, QueryGroup{Name: uri, Reader: bytes.NewReader(bs)})
+	}
+	return groups, nil
+}
+
+// S3ResultWriter writes result sets to S3 objects under the prefix, streaming rows without buffering the whole result set
+type S3ResultWriter struct {
+	LoadAWSDefaultConfigOptions []func(*config.LoadOptions) error
+	ClientOptions               []func(*s3.Options)
+	// Prefix is s3://bucket/prefix/ of the objects
+	Prefix   string
+	Format   OutputFormat
+	Gzip     bool
+	mu       sync.Mutex
+	seq      int
+	s3Client *s3.Client
+}
+
+// S3ResultObject is the result set written to S3
+type S3ResultObject struct {
+	URI            string   `json:"uri"`
+	Query          string   `json:"query"`
+	ResultSetIndex int      `json:"result_set_index"`
+	Columns        []string `json:"columns"`
+	Rows           int64    `json:"rows"`
+}
+
+var outputContentTypes = map[OutputFormat]string{
+	OutputFormatCSV:     "text/csv",
+	OutputFormatJSONL:   "application/x-ndjson",
+	OutputFormatParquet: "application/vnd.apache.parquet",
+}
+
+func (w *S3ResultWriter) client(ctx context.Context) (*s3.Client, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.s3Client == nil {
+		client, err := newS3Client(ctx, w.LoadAWSDefaultConfigOptions, w.ClientOptions)
+		if err != nil {
+			return nil, err
+		}
+		w.s3Client = client
+	}
+	return w.s3Client, nil
+}
+
+// nextKey returns the bucket and the key of the next object, named by the sequence number like prefix/0001.csv.gz
+func (w *S3ResultWriter) nextKey() (string, string, error) {
+	bucket, prefix, err := parseS3URI(w.Prefix)
+	if err != nil {
+		return "", "", err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	w.mu.Lock()
+	w.seq++
+	seq := w.seq
+	w.mu.Unlock()
+	key := fmt.Sprintf("%s%04d.%s", prefix, seq, w.Format)
+	if w.Gzip {
+		key += ".gz"
+	}
+	return bucket, key, nil
+}
+
+// Write writes the rows of the result set to the next object under the prefix.
+// The rows are uploaded by multipart upload while reading, so the memory is bounded by the part size.
+func (w *S3ResultWriter) Write(ctx context.Context, rs *ResultSetReader) (*S3ResultObject, error) {
+	if _, ok := outputContentTypes[w.Format]; !ok {
+		return nil, errors.Errorf("unknown output format `%s`", w.Format)
+	}
+	bucket, key, err := w.nextKey()
+	if err != nil {
+		return nil, err
+	}
+	client, err := w.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(outputContentTypes[w.Format]),
+	}
+	if w.Gzip {
+		input.ContentType = aws.String("application/gzip")
+	}
+	pr, pw := io.Pipe()
+	input.Body = pr
+	uploaded := make(chan error, 1)
+	go func() {
+		_, err := manager.NewUploader(client).Upload(ctx, input)
+		// unblock the writer if the upload failed
+		pr.CloseWithError(err)
+		uploaded <- err
+	}()
+	n, err := w.writeTo(pw, rs)
+	pw.CloseWithError(err)
+	uploadErr := <-uploaded
+	uri := "s3://" + bucket + "/" + key
+	if err != nil {
+		return nil, fmt.Errorf("write result set to `%s`: %w", uri, err)
+	}
+	if uploadErr != nil {
+		return nil, fmt.Errorf("upload result set to `%s`: %w", uri, uploadErr)
+	}
+	return &S3ResultObject{
+		URI:            uri,
+		Query:          rs.Query,
+		ResultSetIndex: rs.Index,
+		Columns:        rs.Columns,
+		Rows:           n,
+	}, nil
+}
+
+func (w *S3ResultWriter) writeTo(dst io.Writer, rs *ResultSetReader) (int64, error) {
+	if !w.Gzip {
+		return WriteResultSet(dst, rs, w.Format)
+	}
+	gz := gzip.NewWriter(dst)
+	n, err := WriteResultSet(gz, rs, w.Format)
+	if err != nil {
+		return n, err
+	}
+	return n, gz.Close()
+}
@@ -0,0 +1,108 @@
+package mysqlbatch_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestS3Fetcher(t *testing.T, objects map[string]string) *mysqlbatch.S3Fetcher {
+	t.Helper()
+	return &mysqlbatch.S3Fetcher{
+		LoadAWSDefaultConfigOptions: []func(*config.LoadOptions) error{
+			config.WithRegion("ap-northeast-1"),
+			config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+			config.WithAPIOptions([]func(stack *middleware.Stack) error{
+				func(stack *middleware.Stack) error {
+					return stack.Initialize.Add(
+						middleware.InitializeMiddlewareFunc("test",
+							func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+								switch params := in.Parameters.(type) {
+								case *s3.GetObjectInput:
+									require.Equal(t, "example-bucket", aws.ToString(params.Bucket))
+									key := aws.ToString(params.Key)
+									if v := aws.ToString(params.VersionId); v != "" {
+										key += "?versionId=" + v
+									}
+									body, ok := objects[key]
+									if !ok {
+										return middleware.InitializeOutput{}, middleware.Metadata{}, &types.NoSuchKey{}
+									}
+									return middleware.InitializeOutput{
+										Result: &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewBufferString(body))},
+									}, middleware.Metadata{}, nil
+								case *s3.ListObjectsV2Input:
+									var contents []types.Object
+									for key := range objects {
+										if strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+											contents = append(contents, types.Object{Key: aws.String(key)})
+										}
+									}
+									return middleware.InitializeOutput{
+										Result: &s3.ListObjectsV2Output{Contents: contents},
+									}, middleware.Metadata{}, nil
+								}
+								return next.HandleInitialize(ctx, in)
+							},
+						),
+						middleware.Before,
+					)
+				},
+			}),
+		},
+	}
+}
+
+func TestS3Fetcher(t *testing.T) {
+	fetcher := newTestS3Fetcher(t, map[string]string{
+		"sql/batch.sql":                    "SELECT 2;",
+		"sql/batch.sql?versionId=v1":       "SELECT 1;",
+		"jobs/daily/02_aggregate.sql":      "SELECT 'aggregate';",
+		"jobs/daily/01_prepare.sql":        "SELECT 'prepare';",
+		"jobs/daily/README.md":             "# daily jobs",
+		"jobs/daily/archive/00_backup.sql": "SELECT 'backup';",
+	})
+	ctx := context.Background()
+
+	actual, err := fetcher.Fetch(ctx, "s3://example-bucket/sql/batch.sql", "")
+	require.NoError(t, err)
+	require.Equal(t, "SELECT 2;", string(actual))
+	actual, err = fetcher.Fetch(ctx, "s3://example-bucket/sql/batch.sql", "v1")
+	require.NoError(t, err)
+	require.Equal(t, "SELECT 1;", string(actual))
+	_, err = fetcher.Fetch(ctx, "s3://example-bucket/sql/notfound.sql", "")
+	require.Error(t, err)
+	_, err = fetcher.Fetch(ctx, "s3://example-bucket/sql/", "")
+	require.Error(t, err)
+	_, err = fetcher.Fetch(ctx, "/var/task/batch.sql", "")
+	require.Error(t, err)
+
+	groups, err := fetcher.FetchGroups(ctx, "s3://example-bucket/jobs/daily/")
+	require.NoError(t, err)
+	var names, queries []string
+	for _, g := range groups {
+		names = append(names, g.Name)
+		bs, err := io.ReadAll(g.Reader)
+		require.NoError(t, err)
+		queries = append(queries, string(bs))
+	}
+	require.Equal(t, []string{
+		"s3://example-bucket/jobs/daily/01_prepare.sql",
+		"s3://example-bucket/jobs/daily/02_aggregate.sql",
+		"s3://example-bucket/jobs/daily/archive/00_backup.sql",
+	}, names)
+	require.Equal(t, []string{"SELECT 'prepare';", "SELECT 'aggregate';", "SELECT 'backup';"}, queries)
+
+	_, err = fetcher.FetchGroups(ctx, "s3://example-bucket/jobs/weekly/")
+	require.EqualError(t, err, "no sql objects found under `s3://example-bucket/jobs/weekly/`")
+}
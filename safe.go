@@ -0,0 +1,132 @@
+package mysqlbatch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UnsafeStatement is a statement rejected by the safe-update guard
+type UnsafeStatement struct {
+	Query  string
+	Reason string
+}
+
+// UnsafeStatementError is returned before execution when the safe-update guard, read-only mode or the policy rejects statements
+type UnsafeStatementError struct {
+	Statements []*UnsafeStatement
+}
+
+func (e *UnsafeStatementError) Error() string {
+	msgs := make([]string, 0, len(e.Statements))
+	for _, stmt := range e.Statements {
+		msgs = append(msgs, fmt.Sprintf("`%s` %s", stmt.Query, stmt.Reason))
+	}
+	return "unsafe statements rejected: " + strings.Join(msgs, "; ")
+}
+
+type safeUpdates struct {
+	session bool
+}
+
+// SetSafeUpdates enables the safe-update guard.
+// All statements of the batch are checked before execution, and the batch is rejected if UPDATE or DELETE has neither WHERE nor LIMIT, or DROP or TRUNCATE is found.
+// A statement annotated with `-- mysqlbatch:allow-unsafe` is not checked.
+// If session is true, sql_safe_updates = 1 is also set on the connections during the execution.
+func (e *Executer) SetSafeUpdates(enabled bool, session bool) {
+	if !enabled {
+		e.safeUpdates = nil
+		return
+	}
+	e.safeUpdates = &safeUpdates{
+		session: session,
+	}
+}
+
+// checkStatements checks all statements by the safe-update guard, read-only mode and the policy before execution
+func (e *Executer) checkStatements(groups []*statementGroup) error {
+	var unsafe []*UnsafeStatement
+	var currentDB string
+	for _, g := range groups {
+		if g.index >= 0 && e.parallelism > 1 {
+			// each group is executed on its own connection
+			currentDB = ""
+		}
+		for _, stmt := range g.statements {
+			tokens := tokenize(stmt.query)
+			if reason := e.rejectReason(stmt, tokens, currentDB); reason != "" {
+				unsafe = append(unsafe, &UnsafeStatement{
+					Query:  e.redactor.Redact(stmt.query),
+					Reason: reason,
+				})
+			}
+			if statementVerb(tokens) == "USE" && len(tokens) > 1 {
+				currentDB, _ = qualifiedName(tokens, 1)
+			}
+		}
+	}
+	if len(unsafe) > 0 {
+		return &UnsafeStatementError{Statements: unsafe}
+	}
+	return nil
+}
+
+// rejectReason returns why the statement is rejected, or empty if allowed
+func (e *Executer) rejectReason(stmt *statement, tokens []token, currentDB string) string {
+	if e.policy != nil {
+		if reason := e.policy.violation(tokens, currentDB); reason != "" {
+			return reason
+		}
+	}
+	if e.readOnly && !isReadOnlyStatement(stmt.query) {
+		return "is not allowed in read-only mode"
+	}
+	if e.safeUpdates == nil {
+		return ""
+	}
+	if _, ok := stmt.annotation("allow-unsafe"); ok {
+		return ""
+	}
+	return unsafeReason(stmt.query)
+}
+
+// unsafeReason returns why the query is unsafe, or empty if safe
+func unsafeReason(query string) string {
+	tokens := tokenize(query)
+	verb := statementVerb(tokens)
+	switch verb {
+	case "DELETE", "UPDATE":
+		for _, t := range tokens {
+			if kw := t.keyword(); t.depth == 0 && (kw == "WHERE" || kw == "LIMIT") {
+				return ""
+			}
+		}
+		return "has neither WHERE nor LIMIT"
+	case "DROP", "TRUNCATE":
+		return "is " + verb
+	}
+	return ""
+}
+
+// setSessionSafeUpdates sets sql_safe_updates = 1 on conn, and returns the function restoring it
+func (e *Executer) setSessionSafeUpdates(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if e.safeUpdates == nil || !e.safeUpdates.session {
+		return func() {}, nil
+	}
+	if _, err := conn.ExecContext(ctx, "SET SESSION sql_safe_updates = 1"); err != nil {
+		return nil, errors.Wrap(err, "set sql_safe_updates")
+	}
+	return func() {
+		// restore even if ctx is canceled, because the connection is returned to the pool
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := conn.ExecContext(ctx, "SET SESSION sql_safe_updates = DEFAULT"); err != nil {
+			log.Printf("restore sql_safe_updates failed: %s", err)
+		}
+	}, nil
+}
@@ -0,0 +1,72 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuterExecute__WithSafeUpdates(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader(testChunkSetupSQL), nil))
+	e.SetSafeUpdates(true, false)
+
+	cases := []struct {
+		query  string
+		unsafe bool
+	}{
+		{query: "DELETE FROM safe_not_exists", unsafe: true},
+		{query: "delete from safe_not_exists where id = 1", unsafe: false},
+		{query: "DELETE FROM safe_not_exists LIMIT 10", unsafe: false},
+		{query: "UPDATE safe_not_exists SET name = 'WHERE'", unsafe: true},
+		{query: "UPDATE safe_not_exists SET id = (SELECT 1 FROM dual WHERE 1 = 1)", unsafe: true},
+		{query: "UPDATE safe_not_exists SET name = 'x' -- WHERE id = 1", unsafe: true},
+		{query: "UPDATE safe_not_exists SET name = 'x' WHERE id IN (1, 2)", unsafe: false},
+		{query: "WITH t AS (SELECT 1) DELETE FROM safe_not_exists", unsafe: true},
+		{query: "DROP TABLE safe_not_exists", unsafe: true},
+		{query: "truncate safe_not_exists", unsafe: true},
+		{query: "-- mysqlbatch:allow-unsafe\nTRUNCATE safe_not_exists", unsafe: false},
+		{query: "SELECT * FROM safe_not_exists", unsafe: false},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			err := e.Execute(strings.NewReader("USE mysqlbatch;\n"+c.query+";"), nil)
+			var unsafeErr *mysqlbatch.UnsafeStatementError
+			require.Equal(t, c.unsafe, errors.As(err, &unsafeErr), "error: %v", err)
+		})
+	}
+
+	err = e.Execute(strings.NewReader(`
+USE mysqlbatch;
+DELETE FROM chunk_events WHERE created_at < '2023-01-01';
+DELETE FROM chunk_events;
+`), nil)
+	var unsafeErr *mysqlbatch.UnsafeStatementError
+	require.ErrorAs(t, err, &unsafeErr)
+	require.Equal(t, []*mysqlbatch.UnsafeStatement{
+		{Query: "DELETE FROM chunk_events", Reason: "has neither WHERE nor LIMIT"},
+	}, unsafeErr.Statements)
+
+	var rows [][]string
+	e.SetSelectHook(func(_ string, _ []string, r [][]string) {
+		rows = r
+	})
+	require.NoError(t, e.Execute(strings.NewReader("SELECT COUNT(*) FROM mysqlbatch.chunk_events;"), nil))
+	require.Equal(t, [][]string{{"26"}}, rows, "no statement is executed if rejected")
+
+	e.SetSafeUpdates(true, true)
+	require.NoError(t, e.Execute(strings.NewReader("SELECT @@SESSION.sql_safe_updates;"), nil))
+	require.Equal(t, [][]string{{"1"}}, rows)
+	e.SetSafeUpdates(false, false)
+	require.NoError(t, e.Execute(strings.NewReader("SELECT @@SESSION.sql_safe_updates;"), nil))
+	require.Equal(t, [][]string{{"0"}}, rows, "sql_safe_updates is restored after execution")
+}
@@ -0,0 +1,86 @@
+package mysqlbatch
+
+import (
+	"regexp"
+	"strings"
+)
+
+// annotationPattern matches the content of a comment like `-- mysqlbatch:chunk size=1000`
+var annotationPattern = regexp.MustCompile(`^mysqlbatch:([\w-]+)(?:\s+(.*))?$`)
+
+// annotation is the parameters of the annotation comment, bare key is set as "true"
+type annotation map[string]string
+
+type statement struct {
+	query       string
+	annotations map[string]annotation
+}
+
+// parseStatement strips leading comments from text and collects annotations in them.
+// Optimizer hints (/*+ */) and executable comments (/*! */) are kept as a part of the query.
+func parseStatement(text string) *statement {
+	stmt := &statement{}
+	rest := text
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n")
+		var comment string
+		switch {
+		case strings.HasPrefix(rest, "--"), strings.HasPrefix(rest, "#"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			comment = strings.TrimPrefix(strings.TrimPrefix(rest[:end], "#"), "--")
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "/*") && !strings.HasPrefix(rest, "/*+") && !strings.HasPrefix(rest, "/*!"):
+			end := strings.Index(rest, "*/")
+			if end < 0 {
+				stmt.query = normalizeQuery(rest)
+				return stmt
+			}
+			comment = rest[2:end]
+			rest = rest[end+2:]
+		default:
+			stmt.query = normalizeQuery(rest)
+			return stmt
+		}
+		stmt.addAnnotation(strings.TrimSpace(comment))
+	}
+}
+
+func (stmt *statement) addAnnotation(comment string) {
+	m := annotationPattern.FindStringSubmatch(comment)
+	if m == nil {
+		return
+	}
+	a := make(annotation)
+	for _, field := range strings.Fields(m[2]) {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			a[key] = value
+		} else {
+			a[field] = "true"
+		}
+	}
+	if stmt.annotations == nil {
+		stmt.annotations = make(map[string]annotation)
+	}
+	stmt.annotations[m[1]] = a
+}
+
+func (stmt *statement) annotation(name string) (annotation, bool) {
+	a, ok := stmt.annotations[name]
+	return a, ok
+}
+
+// isSessionState returns true if the statement changes the session state, like USE or SET
+func (stmt *statement) isSessionState() bool {
+	return ClassifyStatement(stmt.query).Kind == StatementKindSession
+}
+
+func normalizeQuery(s string) string {
+	return strings.Trim(strings.NewReplacer(
+		"\r\n", " ",
+		"\r", " ",
+		"\n", " ",
+	).Replace(s), " \t")
+}
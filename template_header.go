@@ -0,0 +1,197 @@
+package mysqlbatch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	templateHeaderBegin = "/*---"
+	templateHeaderEnd   = "---*/"
+)
+
+// TemplateHeader is a front-matter comment at the beginning of the SQL template.
+//
+//	/*---
+//	parameters:
+//	  - name: limit
+//	    type: int
+//	    default: 10
+//	    description: max rows of result
+//	---*/
+type TemplateHeader struct {
+	Parameters []*TemplateParameter `yaml:"parameters,omitempty"`
+}
+
+// TemplateParameter is a parameter declared in TemplateHeader
+type TemplateParameter struct {
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type,omitempty"`
+	Default     *string  `yaml:"default,omitempty"`
+	Allowed     []string `yaml:"allowed,omitempty"`
+	Description string   `yaml:"description,omitempty"`
+}
+
+// ParseTemplateHeader splits the SQL template into TemplateHeader and body.
+// If the template has no header, returns nil TemplateHeader and the template as it is.
+func ParseTemplateHeader(bs []byte) (*TemplateHeader, []byte, error) {
+	trimmed := bytes.TrimLeft(bs, " \t\r\n")
+	if !bytes.HasPrefix(trimmed, []byte(templateHeaderBegin)) {
+		return nil, bs, nil
+	}
+	rest := trimmed[len(templateHeaderBegin):]
+	end := bytes.Index(rest, []byte(templateHeaderEnd))
+	if end < 0 {
+		return nil, nil, errors.Errorf("template header is not closed by `%s`", templateHeaderEnd)
+	}
+	var header TemplateHeader
+	dec := yaml.NewDecoder(bytes.NewReader(rest[:end]))
+	dec.KnownFields(true)
+	if err := dec.Decode(&header); err != nil && err != io.EOF {
+		return nil, nil, errors.Wrap(err, "parse template header failed")
+	}
+	if err := header.validate(); err != nil {
+		return nil, nil, err
+	}
+	return &header, rest[end+len(templateHeaderEnd):], nil
+}
+
+func (h *TemplateHeader) validate() error {
+	names := make(map[string]bool, len(h.Parameters))
+	for i, p := range h.Parameters {
+		if p == nil || p.Name == "" {
+			return errors.Errorf("template header: parameters[%d] name is required", i)
+		}
+		if names[p.Name] {
+			return errors.Errorf("template header: parameter %s is declared twice", p.Name)
+		}
+		names[p.Name] = true
+		if _, ok := templateParameterTypes[p.TypeName()]; !ok {
+			return errors.Errorf("template header: parameter %s has unknown type `%s`", p.Name, p.Type)
+		}
+		for _, v := range p.Allowed {
+			if err := p.checkType(v); err != nil {
+				return errors.Wrap(err, "template header")
+			}
+		}
+		if p.Default != nil {
+			if err := p.Validate(*p.Default); err != nil {
+				return errors.Wrap(err, "template header: default value")
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyVars validates vars against the declared parameters and returns vars with default values.
+// Undeclared vars are ignored with a log, so that one set of vars can be shared by templates declaring different parameters.
+func (h *TemplateHeader) ApplyVars(vars map[string]string) (map[string]string, error) {
+	applied := make(map[string]string, len(h.Parameters))
+	declared := make(map[string]bool, len(h.Parameters))
+	var msgs []string
+	for _, p := range h.Parameters {
+		declared[p.Name] = true
+		v, ok := vars[p.Name]
+		if !ok {
+			if p.Default == nil {
+				msgs = append(msgs, fmt.Sprintf("variable %s is required", p.Name))
+				continue
+			}
+			v = *p.Default
+		}
+		if err := p.Validate(v); err != nil {
+			msgs = append(msgs, err.Error())
+			continue
+		}
+		applied[p.Name] = v
+	}
+	undeclared := make([]string, 0)
+	for key := range vars {
+		if !declared[key] {
+			undeclared = append(undeclared, key)
+		}
+	}
+	sort.Strings(undeclared)
+	for _, key := range undeclared {
+		log.Printf("variable %s is not declared, ignored", key)
+	}
+	if len(msgs) > 0 {
+		return nil, errors.Errorf("invalid variables: %s", strings.Join(msgs, ", "))
+	}
+	return applied, nil
+}
+
+// TypeName returns the type of the parameter, default is string
+func (p *TemplateParameter) TypeName() string {
+	if p.Type == "" {
+		return "string"
+	}
+	return strings.ToLower(p.Type)
+}
+
+// Required returns true if the parameter has no default value
+func (p *TemplateParameter) Required() bool {
+	return p.Default == nil
+}
+
+// Validate checks that the value satisfies the type and the allowed values
+func (p *TemplateParameter) Validate(value string) error {
+	if err := p.checkType(value); err != nil {
+		return err
+	}
+	if len(p.Allowed) == 0 {
+		return nil
+	}
+	for _, allowed := range p.Allowed {
+		if value == allowed {
+			return nil
+		}
+	}
+	return errors.Errorf("variable %s must be one of [%s], got `%s`", p.Name, strings.Join(p.Allowed, ", "), value)
+}
+
+func (p *TemplateParameter) checkType(value string) error {
+	check, ok := templateParameterTypes[p.TypeName()]
+	if !ok {
+		return errors.Errorf("variable %s has unknown type `%s`", p.Name, p.Type)
+	}
+	if err := check(value); err != nil {
+		return errors.Errorf("variable %s must be %s, got `%s`", p.Name, p.TypeName(), value)
+	}
+	return nil
+}
+
+var templateParameterTypes = map[string]func(string) error{
+	"string": func(string) error {
+		return nil
+	},
+	"int": func(s string) error {
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err
+	},
+	"float": func(s string) error {
+		_, err := strconv.ParseFloat(s, 64)
+		return err
+	},
+	"bool": func(s string) error {
+		_, err := strconv.ParseBool(s)
+		return err
+	},
+	"date": func(s string) error {
+		_, err := time.Parse(time.DateOnly, s)
+		return err
+	},
+	"datetime": func(s string) error {
+		_, err := time.Parse(time.DateTime, s)
+		return err
+	},
+}
@@ -0,0 +1,91 @@
+package mysqlbatch_test
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+//go:embed testdata/test_header.sql
+var testHeaderSQL []byte
+
+func TestParseTemplateHeader(t *testing.T) {
+	header, body, err := mysqlbatch.ParseTemplateHeader(testHeaderSQL)
+	require.NoError(t, err)
+	require.Len(t, header.Parameters, 2)
+	require.Equal(t, "relation", header.Parameters[0].Name)
+	require.Equal(t, "string", header.Parameters[0].TypeName())
+	require.False(t, header.Parameters[0].Required())
+	require.Equal(t, "int", header.Parameters[1].TypeName())
+	require.True(t, header.Parameters[1].Required())
+	require.Equal(t, "\nSELECT * FROM {{ var(\"relation\", \"hoge\") }} WHERE age is NOT NULL LIMIT {{ must_var(\"limit\") }};\n", string(body))
+
+	header, body, err = mysqlbatch.ParseTemplateHeader(testSQL)
+	require.NoError(t, err)
+	require.Nil(t, header)
+	require.Equal(t, testSQL, body)
+}
+
+func TestParseTemplateHeader__Invalid(t *testing.T) {
+	cases := map[string]string{
+		"not closed":      "/*---\nparameters: []\nSELECT 1;",
+		"unknown field":   "/*---\nparams: []\n---*/\nSELECT 1;",
+		"no name":         "/*---\nparameters:\n  - type: int\n---*/\nSELECT 1;",
+		"duplicated":      "/*---\nparameters:\n  - name: a\n  - name: a\n---*/\nSELECT 1;",
+		"unknown type":    "/*---\nparameters:\n  - name: a\n    type: uuid\n---*/\nSELECT 1;",
+		"invalid default": "/*---\nparameters:\n  - name: a\n    type: int\n    default: x\n---*/\nSELECT 1;",
+	}
+	for name, input := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := mysqlbatch.ParseTemplateHeader([]byte(input))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestTemplateHeaderApplyVars(t *testing.T) {
+	header, _, err := mysqlbatch.ParseTemplateHeader(testHeaderSQL)
+	require.NoError(t, err)
+
+	vars, err := header.ApplyVars(map[string]string{"limit": "5"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"relation": "users", "limit": "5"}, vars)
+
+	_, err = header.ApplyVars(map[string]string{"relation": "groups", "limit": "five"})
+	require.EqualError(t, err, "invalid variables: variable relation must be one of [users, admins], got `groups`, variable limit must be int, got `five`")
+
+	// undeclared vars are ignored, e.g. vars shared by files
+	vars, err = header.ApplyVars(map[string]string{"limit": "5", "tenant": "foo"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"relation": "users", "limit": "5"}, vars)
+
+	_, err = header.ApplyVars(nil)
+	require.EqualError(t, err, "invalid variables: variable limit is required")
+}
+
+func TestExecuterExecute__WithTemplateHeader(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+
+	err = e.Execute(bytes.NewReader(testSQL), nil)
+	require.NoError(t, err)
+	var queries []string
+	e.SetSelectHook(func(query string, columns []string, rows [][]string) {
+		queries = append(queries, query)
+	})
+	err = e.Execute(bytes.NewReader(testHeaderSQL), map[string]string{"limit": "3"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"SELECT * FROM users WHERE age is NOT NULL LIMIT 3"}, queries)
+
+	err = e.Execute(strings.NewReader(string(testHeaderSQL)), nil)
+	require.EqualError(t, err, "invalid variables: variable limit is required")
+}
@@ -0,0 +1,11 @@
+/*---
+parameters:
+  - name: relation
+    default: users
+    allowed: [users, admins]
+    description: target table
+  - name: limit
+    type: int
+    description: max rows of result
+---*/
+SELECT * FROM {{ var("relation", "hoge") }} WHERE age is NOT NULL LIMIT {{ must_var("limit") }};
@@ -0,0 +1,107 @@
+package mysqlbatch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Warning is a row of SHOW WARNINGS
+type Warning struct {
+	Level   string `json:"level"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (w *Warning) String() string {
+	return fmt.Sprintf("%s (Code %d): %s", w.Level, w.Code, w.Message)
+}
+
+// WarningsError is returned when the statement has warnings in warnings-as-errors mode
+type WarningsError struct {
+	Query    string
+	Warnings []*Warning
+}
+
+func (e *WarningsError) Error() string {
+	msgs := make([]string, 0, len(e.Warnings))
+	for _, w := range e.Warnings {
+		msgs = append(msgs, w.String())
+	}
+	return fmt.Sprintf("query `%s` has warnings: %s", e.Query, strings.Join(msgs, "; "))
+}
+
+// SetWarningHook set the hook called with warnings of each statement, fetched by SHOW WARNINGS on the same connection.
+// For a chunked statement, warnings of all chunks are passed.
+func (e *Executer) SetWarningHook(hook func(query string, warnings []*Warning)) {
+	e.warningHook = hook
+}
+
+// SetWarningsAsErrors set whether the statement with warnings fails. Notes are not treated as errors.
+func (e *Executer) SetWarningsAsErrors(b bool) {
+	e.warningsAsErrors = b
+}
+
+func (e *Executer) fetchesWarnings() bool {
+	return e.warningHook != nil || e.warningsAsErrors
+}
+
+// showWarnings returns warnings of the last statement on q, with the messages redacted
+func (e *Executer) showWarnings(ctx context.Context, q queryer) ([]*Warning, error) {
+	iter, err := q.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return nil, errors.Wrap(err, "show warnings")
+	}
+	defer iter.Close()
+	var warnings []*Warning
+	for iter.Next() {
+		var w Warning
+		var message sql.NullString
+		if err := iter.Scan(&w.Level, &w.Code, &message); err != nil {
+			return nil, errors.Wrap(err, "scan warnings")
+		}
+		w.Message = e.redactor.Redact(message.String)
+		warnings = append(warnings, &w)
+	}
+	return warnings, iter.Err()
+}
+
+// fetchWarnings returns warnings of the last statement on q if the hook or warnings-as-errors mode is set
+func (e *Executer) fetchWarnings(ctx context.Context, q queryer, query string) ([]*Warning, error) {
+	if !e.fetchesWarnings() {
+		return nil, nil
+	}
+	// SHOW WARNINGS does not clear warnings, so SHOW statements would report warnings of the previous statement again
+	if ClassifyStatement(query).Verb == "SHOW" {
+		return nil, nil
+	}
+	return e.showWarnings(ctx, q)
+}
+
+// reportWarnings emits warnings to the hook, and returns WarningsError in warnings-as-errors mode
+func (e *Executer) reportWarnings(redactedQuery string, warnings []*Warning, emit func(func())) error {
+	if len(warnings) == 0 {
+		return nil
+	}
+	if e.warningHook != nil {
+		emit(func() {
+			e.warningHook(redactedQuery, warnings)
+		})
+	}
+	if !e.warningsAsErrors {
+		return nil
+	}
+	var errs []*Warning
+	for _, w := range warnings {
+		if !strings.EqualFold(w.Level, "Note") {
+			errs = append(errs, w)
+		}
+	}
+	if len(errs) > 0 {
+		return &WarningsError{Query: redactedQuery, Warnings: errs}
+	}
+	return nil
+}
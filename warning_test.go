@@ -0,0 +1,47 @@
+package mysqlbatch_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/mysqlbatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuterExecute__WithWarnings(t *testing.T) {
+	conf := mysqlbatch.NewDefaultConfig()
+	conf.Password = "mysqlbatch"
+	conf.Location = "Asia/Tokyo"
+	e, err := mysqlbatch.New(context.Background(), conf)
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, e.Execute(strings.NewReader("CREATE DATABASE IF NOT EXISTS mysqlbatch;"), nil))
+
+	codes := make(map[string][]string)
+	e.SetWarningHook(func(query string, warnings []*mysqlbatch.Warning) {
+		for _, w := range warnings {
+			codes[query] = append(codes[query], fmt.Sprintf("%s %d", w.Level, w.Code))
+		}
+	})
+	err = e.Execute(strings.NewReader(`
+CREATE DATABASE IF NOT EXISTS mysqlbatch;
+SELECT 1/0;
+SHOW WARNINGS;
+SELECT 1;
+`), nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"CREATE DATABASE IF NOT EXISTS mysqlbatch": {"Note 1007"},
+		"SELECT 1/0": {"Warning 1365"},
+	}, codes)
+
+	e.SetWarningHook(nil)
+	e.SetWarningsAsErrors(true)
+	require.NoError(t, e.Execute(strings.NewReader("CREATE DATABASE IF NOT EXISTS mysqlbatch;"), nil), "notes are not errors")
+	err = e.Execute(strings.NewReader("SELECT 1/0;"), nil)
+	var warningsErr *mysqlbatch.WarningsError
+	require.ErrorAs(t, err, &warningsErr)
+	require.EqualError(t, err, "query `SELECT 1/0` has warnings: Warning (Code 1365): Division by 0")
+}